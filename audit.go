@@ -0,0 +1,313 @@
+package shovel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit events
+const (
+	AuditJobRejected = "job.rejected" // The policy denied a request
+	AuditJobStarted  = "job.started"
+	AuditJobResumed  = "job.resumed" // A job was resumed after its instance shut down
+	AuditJobMessages = "job.messages"
+	AuditJobFinished = "job.finished"
+)
+
+const (
+	auditTimeout   = 30 * time.Second
+	auditBatchSize = 1000 // Message IDs per job.messages record
+	auditQueueSize = 1000 // Records waiting for the sink, more are dropped
+)
+
+// auditEnqueueTimeout is how long lifecycle records wait for room in a full
+// queue before they are dropped
+var auditEnqueueTimeout = 5 * time.Second
+
+// Results of audit writes, the labels of shovel_audit_records_total
+const (
+	auditResultWritten = "written"
+	auditResultFailed  = "failed"
+	auditResultDropped = "dropped"
+)
+
+// AuditRecord is one entry of the audit log
+type AuditRecord struct {
+	Time       time.Time       `json:"time"`
+	Event      string          `json:"event"`
+	JobID      string          `json:"jobId,omitempty"`
+	Principal  string          `json:"principal"`
+	Request    *ShovelRequest  `json:"request,omitempty"`
+	Decision   *PolicyDecision `json:"decision,omitempty"`
+	Status     *JobStatus      `json:"status,omitempty"`
+	MessageIDs []string        `json:"messageIds,omitempty"` // Source IDs of moved messages, when enabled
+}
+
+// AuditSink stores audit records. Sinks must be safe for concurrent use.
+type AuditSink interface {
+	Write(ctx context.Context, record AuditRecord) error
+}
+
+var (
+	// auditSink receives all audit records. nil disables auditing.
+	auditSink AuditSink
+	// auditMessageIDs adds the IDs of moved messages to the audit log
+	auditMessageIDs bool
+)
+
+func init() {
+	sink, err := NewAuditSinkFromEnv()
+	if err != nil {
+		logger.Error("Failed to configure audit sink, writing audit records to stdout", "error", err)
+		sink = NewLogAuditSink(os.Stdout)
+	}
+	auditSink = sink
+	auditMessageIDs = os.Getenv("SHOVEL_AUDIT_MESSAGE_IDS") == "true"
+}
+
+// SetAuditSink replaces the audit sink. nil disables auditing. messageIDs
+// records the IDs of moved messages.
+func SetAuditSink(sink AuditSink, messageIDs bool) {
+	auditSink = sink
+	auditMessageIDs = messageIDs
+}
+
+// NewAuditSinkFromEnv creates the sink configured by SHOVEL_AUDIT_SINK: "log"
+// for the audit log stream on stdout, "file:PATH" to append to a file, or
+// "projects/P/topics/T" to publish to a Pub/Sub topic. It returns nil when
+// auditing is not configured.
+func NewAuditSinkFromEnv() (AuditSink, error) {
+	spec := os.Getenv("SHOVEL_AUDIT_SINK")
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "log":
+		return NewLogAuditSink(os.Stdout), nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileAuditSink(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "projects/"):
+		topic, err := ParseTopic(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid audit topic: %v", err)
+		}
+		return &TopicAuditSink{Topic: topic.String()}, nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", spec)
+	}
+}
+
+// JSONAuditSink writes one JSON record per line
+type JSONAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFileAuditSink appends audit records to the file at path
+func NewFileAuditSink(path string) (*JSONAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %v", err)
+	}
+	return &JSONAuditSink{w: f}, nil
+}
+
+// Write appends record to the file
+func (s *JSONAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	return s.writeLine(record)
+}
+
+func (s *JSONAuditSink) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// LogAuditSink writes audit records as structured log entries. The entries
+// carry the label stream=audit so that a log router can keep them apart from
+// the operational logs on stderr.
+type LogAuditSink struct {
+	JSONAuditSink
+}
+
+// NewLogAuditSink writes audit log entries to w
+func NewLogAuditSink(w io.Writer) *LogAuditSink {
+	return &LogAuditSink{JSONAuditSink{w: w}}
+}
+
+// Write logs record
+func (s *LogAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	return s.writeLine(map[string]interface{}{
+		"time":                          record.Time,
+		"severity":                      "NOTICE",
+		"message":                       "Audit " + record.Event,
+		"logging.googleapis.com/labels": map[string]string{"stream": "audit"},
+		"audit":                         record,
+	})
+}
+
+// TopicAuditSink publishes audit records to a Pub/Sub topic
+type TopicAuditSink struct {
+	Topic string // projects/P/topics/T
+}
+
+// Write publishes record with the event and job ID as attributes
+func (s *TopicAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return publishNotification(ctx, s.Topic, payload, map[string]string{"event": record.Event, "jobId": record.JobID})
+}
+
+// auditItem is a queued audit record, or a flush marker when done is set
+type auditItem struct {
+	sink   AuditSink
+	record AuditRecord
+	done   chan struct{}
+}
+
+var (
+	// auditQueue keeps slow sinks away from requests and message handling.
+	// A single writer drains it in order.
+	auditQueue       = make(chan auditItem, auditQueueSize)
+	startAuditWriter sync.Once
+)
+
+// writeAudits writes the queued records until the process exits
+func writeAudits() {
+	for item := range auditQueue {
+		if item.done != nil {
+			close(item.done)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+		err := item.sink.Write(ctx, item.record)
+		cancel()
+		if err != nil {
+			auditRecords.WithLabelValues(auditResultFailed).Inc()
+			logger.Error("Failed to write audit record", "event", item.record.Event, "jobId", item.record.JobID, "error", err)
+			continue
+		}
+		auditRecords.WithLabelValues(auditResultWritten).Inc()
+	}
+}
+
+// writeAudit queues record for the audit sink. job.messages records are
+// dropped when the queue is full, lifecycle records wait up to
+// auditEnqueueTimeout for room first. Drops are counted and logged, failures
+// of the sink are logged by the writer.
+func writeAudit(record AuditRecord) {
+	sink := auditSink
+	if sink == nil {
+		return
+	}
+	record.Time = time.Now()
+	startAuditWriter.Do(func() { go writeAudits() })
+	item := auditItem{sink: sink, record: record}
+	select {
+	case auditQueue <- item:
+		return
+	default:
+	}
+	if record.Event != AuditJobMessages {
+		timer := time.NewTimer(auditEnqueueTimeout)
+		defer timer.Stop()
+		select {
+		case auditQueue <- item:
+			return
+		case <-timer.C:
+		}
+	}
+	auditRecords.WithLabelValues(auditResultDropped).Inc()
+	logger.Error("Audit queue is full, dropping audit record", "event", record.Event, "jobId", record.JobID)
+}
+
+// FlushAudit waits until the audit records queued so far are written. Call it
+// on shutdown once all jobs finished and before CloseClients.
+func FlushAudit(ctx context.Context) error {
+	startAuditWriter.Do(func() { go writeAudits() })
+	done := make(chan struct{})
+	select {
+	case auditQueue <- auditItem{done: done}:
+	case <-ctx.Done():
+		return fmt.Errorf("failed to flush audit records: %v", ctx.Err())
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to flush audit records: %v", ctx.Err())
+	}
+}
+
+// auditRejected records a request the policy denied
+func auditRejected(ctx context.Context, req ShovelRequest, err *PolicyError) {
+	writeAudit(AuditRecord{
+		Event:     AuditJobRejected,
+		Principal: PrincipalFromContext(ctx).String(),
+		Request:   &req,
+		Decision:  &PolicyDecision{Rule: err.Rule},
+	})
+}
+
+// audit records a lifecycle event of the job. Started and resumed records
+// include the policy decision, finished records the message IDs not yet
+// recorded.
+func (j *Job) audit(event string) {
+	if auditSink == nil {
+		return
+	}
+	status := j.Status()
+	record := AuditRecord{
+		Event:     event,
+		JobID:     j.ID,
+		Principal: j.Principal.String(),
+		Request:   &j.Request,
+		Status:    &status,
+	}
+	switch event {
+	case AuditJobStarted, AuditJobResumed:
+		decision := policy.Decide(policyRequest(j.Principal, j.Request))
+		record.Decision = &decision
+	case AuditJobFinished:
+		j.mu.Lock()
+		record.MessageIDs, j.auditIDs = j.auditIDs, nil
+		j.mu.Unlock()
+	}
+	writeAudit(record)
+}
+
+// auditMessage remembers the ID of a moved message and writes a
+// job.messages record once a batch is complete
+func (j *Job) auditMessage(id string) {
+	if auditSink == nil || !auditMessageIDs {
+		return
+	}
+	j.mu.Lock()
+	j.auditIDs = append(j.auditIDs, id)
+	var batch []string
+	if len(j.auditIDs) >= auditBatchSize {
+		batch, j.auditIDs = j.auditIDs, nil
+	}
+	j.mu.Unlock()
+
+	if batch != nil {
+		writeAudit(AuditRecord{
+			Event:      AuditJobMessages,
+			JobID:      j.ID,
+			Principal:  j.Principal.String(),
+			MessageIDs: batch,
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package shovel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// memoryAuditSink keeps audit records in memory
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (s *memoryAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+// events returns the events of all records in order
+func (s *memoryAuditSink) events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []string
+	for _, record := range s.records {
+		events = append(events, record.Event)
+	}
+	return events
+}
+
+// useAuditSink records audit records in memory for the rest of the test
+func useAuditSink(t *testing.T, messageIDs bool) *memoryAuditSink {
+	t.Helper()
+	originalSink, originalIDs := auditSink, auditMessageIDs
+	sink := &memoryAuditSink{}
+	SetAuditSink(sink, messageIDs)
+	t.Cleanup(func() { SetAuditSink(originalSink, originalIDs) })
+	return sink
+}
+
+// flushAudit waits until the queued audit records are written
+func flushAudit(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := FlushAudit(ctx); err != nil {
+		t.Fatalf("Failed to flush audit records: %v", err)
+	}
+}
+
+// blockingAuditSink fails all records, but only once released
+type blockingAuditSink struct {
+	release chan struct{}
+}
+
+func (s *blockingAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	<-s.release
+	return errors.New("sink unavailable")
+}
+
+func TestWriteAudit_Queue(t *testing.T) {
+	flushAudit(t)
+	originalSink, originalIDs := auditSink, auditMessageIDs
+	sink := &blockingAuditSink{release: make(chan struct{})}
+	SetAuditSink(sink, false)
+	t.Cleanup(func() { SetAuditSink(originalSink, originalIDs) })
+	originalTimeout := auditEnqueueTimeout
+	t.Cleanup(func() { auditEnqueueTimeout = originalTimeout })
+	failed := testutil.ToFloat64(auditRecords.WithLabelValues(auditResultFailed))
+	dropped := testutil.ToFloat64(auditRecords.WithLabelValues(auditResultDropped))
+
+	// The writer holds one record, the queue the next auditQueueSize
+	writeAudit(AuditRecord{Event: AuditJobMessages, JobID: "shovel-audit-queue-test"})
+	waitFor(t, "the writer to take the first record", func() bool { return len(auditQueue) == 0 })
+	start := time.Now()
+	for i := 0; i < auditQueueSize+10; i++ {
+		writeAudit(AuditRecord{Event: AuditJobMessages, JobID: "shovel-audit-queue-test"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected audit writes not to wait for the sink, took %v", elapsed)
+	}
+	if n := testutil.ToFloat64(auditRecords.WithLabelValues(auditResultDropped)) - dropped; n != 10 {
+		t.Errorf("Expected the records beyond the queue to be dropped, got %v", n)
+	}
+
+	// Lifecycle records wait for room in the queue before they are dropped
+	auditEnqueueTimeout = 100 * time.Millisecond
+	start = time.Now()
+	writeAudit(AuditRecord{Event: AuditJobFinished, JobID: "shovel-audit-queue-test"})
+	if elapsed := time.Since(start); elapsed < auditEnqueueTimeout {
+		t.Errorf("Expected the finished record to wait for the queue, took %v", elapsed)
+	}
+	if n := testutil.ToFloat64(auditRecords.WithLabelValues(auditResultDropped)) - dropped; n != 11 {
+		t.Errorf("Expected the finished record to be dropped after the timeout, got %v drops", n)
+	}
+
+	auditEnqueueTimeout = 10 * time.Second
+	time.AfterFunc(100*time.Millisecond, func() { close(sink.release) })
+	writeAudit(AuditRecord{Event: AuditJobFinished, JobID: "shovel-audit-queue-test"})
+	if n := testutil.ToFloat64(auditRecords.WithLabelValues(auditResultDropped)) - dropped; n != 11 {
+		t.Errorf("Expected the finished record to be queued once the sink caught up, got %v drops", n)
+	}
+
+	flushAudit(t)
+	if n := testutil.ToFloat64(auditRecords.WithLabelValues(auditResultFailed)) - failed; int(n) != auditQueueSize+2 {
+		t.Errorf("Expected %d failed records, got %v", auditQueueSize+2, n)
+	}
+}
+
+func TestNewAuditSinkFromEnv(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expected    interface{}
+		expectError bool
+	}{
+		{spec: "", expected: nil},
+		{spec: "log", expected: &LogAuditSink{}},
+		{spec: "file:" + filepath.Join(t.TempDir(), "audit.jsonl"), expected: &JSONAuditSink{}},
+		{spec: "projects/test/topics/audit", expected: &TopicAuditSink{}},
+		{spec: "file:" + filepath.Join(t.TempDir(), "missing", "audit.jsonl"), expectError: true},
+		{spec: "projects/test/subscriptions/audit", expectError: true},
+		{spec: "syslog", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SHOVEL_AUDIT_SINK", tt.spec)
+		sink, err := NewAuditSinkFromEnv()
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %q, got none", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected no error for %q, got %v", tt.spec, err)
+			continue
+		}
+		if tt.expected == nil {
+			if sink != nil {
+				t.Errorf("Expected auditing to be disabled, got %T", sink)
+			}
+			continue
+		}
+		if got, want := fmt.Sprintf("%T", sink), fmt.Sprintf("%T", tt.expected); got != want {
+			t.Errorf("Expected %s for %q, got %s", want, tt.spec, got)
+		}
+	}
+}
+
+func TestLogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewLogAuditSink(&buf)
+	if err := sink.Write(context.Background(), AuditRecord{Event: AuditJobStarted, JobID: "shovel-1", Principal: "apikey:ops"}); err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to decode entry: %v", err)
+	}
+	if entry["severity"] != "NOTICE" || entry["message"] != "Audit job.started" {
+		t.Errorf("Expected Cloud Logging fields, got %v", entry)
+	}
+	labels, _ := entry["logging.googleapis.com/labels"].(map[string]interface{})
+	if labels["stream"] != "audit" {
+		t.Errorf("Expected audit stream label, got %v", labels)
+	}
+	audit, _ := entry["audit"].(map[string]interface{})
+	if audit["jobId"] != "shovel-1" || audit["principal"] != "apikey:ops" {
+		t.Errorf("Expected audit record, got %v", audit)
+	}
+}
+
+func TestFileAuditSink_Appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		sink, err := NewFileAuditSink(path)
+		if err != nil {
+			t.Fatalf("Failed to open sink: %v", err)
+		}
+		if err := sink.Write(context.Background(), AuditRecord{Event: AuditJobStarted}); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit file: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expected 2 records, got %d", lines)
+	}
+}
+
+func TestTopicAuditSink(t *testing.T) {
+	srv, _ := newTestTopic(t, "audit")
+	useTestServer(t, srv)
+
+	sink := &TopicAuditSink{Topic: "projects/test/topics/audit"}
+	if err := sink.Write(context.Background(), AuditRecord{Event: AuditJobFinished, JobID: "shovel-1"}); err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 audit message, got %d", len(msgs))
+	}
+	if msgs[0].Attributes["event"] != AuditJobFinished || msgs[0].Attributes["jobId"] != "shovel-1" {
+		t.Errorf("Unexpected attributes: %v", msgs[0].Attributes)
+	}
+}
+
+func TestAudit_JobLifecycle(t *testing.T) {
+	// More messages than requested, so that the job stops at the limit
+	srv := newShovelFixture(t, 5)
+	useTestServer(t, srv)
+	sink := useAuditSink(t, true)
+
+	ctx := withPrincipal(context.Background(), Principal{Kind: PrincipalAPIKey, Name: "ops"})
+	job := startJob(ctx, "shovel-audit-test", ShovelRequest{
+		NumMessages:        3,
+		SourceSubscription: "projects/test/subscriptions/source-sub",
+		TargetTopic:        "projects/test/topics/target",
+	})
+	<-job.Done()
+	flushAudit(t)
+
+	events := sink.events()
+	if len(events) != 2 || events[0] != AuditJobStarted || events[1] != AuditJobFinished {
+		t.Fatalf("Expected started and finished records, got %v", events)
+	}
+	started, finished := sink.records[0], sink.records[1]
+	if started.Principal != "apikey:ops" || started.Decision == nil || !started.Decision.Allowed {
+		t.Errorf("Expected principal and policy decision, got %+v", started)
+	}
+	if finished.Status == nil || finished.Status.ProcessedCount != 3 || finished.Status.FinishedAt == nil {
+		t.Errorf("Expected final counts, got %+v", finished.Status)
+	}
+
+	var expected []string
+	for _, msg := range srv.Messages() {
+		if msg.Acks > 0 {
+			expected = append(expected, msg.ID)
+		}
+	}
+	got := append([]string(nil), finished.MessageIDs...)
+	sort.Strings(expected)
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected message IDs %v, got %v", expected, got)
+	}
+}
+
+func TestAudit_MessageBatches(t *testing.T) {
+	sink := useAuditSink(t, true)
+	job := newJob("shovel-audit-batch-test", ShovelRequest{})
+	for i := 0; i < auditBatchSize+5; i++ {
+		job.auditMessage("id")
+	}
+	job.finish(nil)
+	flushAudit(t)
+
+	if len(sink.records) != 2 {
+		t.Fatalf("Expected a batch and a finished record, got %v", sink.events())
+	}
+	if sink.records[0].Event != AuditJobMessages || len(sink.records[0].MessageIDs) != auditBatchSize {
+		t.Errorf("Expected a full batch, got %s with %d IDs", sink.records[0].Event, len(sink.records[0].MessageIDs))
+	}
+	if len(sink.records[1].MessageIDs) != 5 {
+		t.Errorf("Expected the remaining IDs in the finished record, got %d", len(sink.records[1].MessageIDs))
+	}
+
+	useAuditSink(t, false)
+	job.auditMessage("id")
+	if len(job.auditIDs) != 0 {
+		t.Error("Expected message IDs not to be kept when disabled")
+	}
+}
+
+func TestHandler_AuditsPolicyRejection(t *testing.T) {
+	sink := useAuditSink(t, false)
+	usePolicy(t, &Policy{Deny: []PolicyRule{{Name: "no-prod", Sources: []string{"projects/prod/*/*"}}}})
+
+	body, _ := json.Marshal(ShovelRequest{
+		NumMessages:        1,
+		SourceSubscription: "projects/prod/subscriptions/orders",
+		TargetTopic:        "projects/test/topics/target",
+	})
+	rr := httptest.NewRecorder()
+	Handler(rr, httptest.NewRequest("POST", "/", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+	flushAudit(t)
+
+	if len(sink.records) != 1 {
+		t.Fatalf("Expected 1 audit record, got %v", sink.events())
+	}
+	record := sink.records[0]
+	if record.Event != AuditJobRejected || record.Decision == nil || record.Decision.Allowed || record.Decision.Rule != "no-prod" {
+		t.Errorf("Expected rejection by no-prod, got %+v", record)
+	}
+	if record.Request == nil || record.Request.SourceSubscription != "projects/prod/subscriptions/orders" || record.Principal != PrincipalAnonymous {
+		t.Errorf("Expected request and principal, got %+v", record)
+	}
+}
@@ -0,0 +1,220 @@
+package shovel
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"google.golang.org/api/idtoken"
+)
+
+// APIKeyHeader carries static API keys
+const APIKeyHeader = "X-API-Key"
+
+// Principal kinds
+const (
+	PrincipalIDToken   = "idtoken"   // Google-signed ID token, Name is the email or subject
+	PrincipalAPIKey    = "apikey"    // Static API key, Name is the key's name
+	PrincipalAnonymous = "anonymous" // Authentication is disabled
+)
+
+// ErrUnauthenticated is returned when a request carries no valid credentials
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal identifies the caller that started or changed a job
+type Principal struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+}
+
+// String returns the principal as "kind:name"
+func (p Principal) String() string {
+	if p.Name == "" {
+		return p.Kind
+	}
+	return p.Kind + ":" + p.Name
+}
+
+// Authenticator establishes the principal of a request. It returns
+// ErrUnauthenticated (possibly wrapped) when the request carries none of the
+// credentials it understands.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// authenticator checks all job requests. nil disables authentication.
+var authenticator Authenticator
+
+func init() {
+	a, err := NewAuthenticatorFromEnv()
+	if err != nil {
+		logger.Error("Failed to configure authentication, rejecting all requests", "error", err)
+		a = denyAll{}
+	}
+	authenticator = a
+}
+
+// SetAuthenticator replaces the authenticator. nil disables authentication.
+func SetAuthenticator(a Authenticator) {
+	authenticator = a
+}
+
+// NewAuthenticatorFromEnv builds the authenticator configured by
+// SHOVEL_AUTH_AUDIENCE (accept Google ID tokens for this audience),
+// SHOVEL_AUTH_ALLOWED (comma-separated email patterns allowed to use ID
+// tokens, e.g. "*@my-project.iam.gserviceaccount.com") and SHOVEL_API_KEYS
+// (comma-separated name:key pairs). It returns nil when none is set.
+func NewAuthenticatorFromEnv() (Authenticator, error) {
+	var chain Authenticators
+	if audience := os.Getenv("SHOVEL_AUTH_AUDIENCE"); audience != "" {
+		var allowed []string
+		if v := os.Getenv("SHOVEL_AUTH_ALLOWED"); v != "" {
+			allowed = strings.Split(v, ",")
+		}
+		chain = append(chain, &IDTokenAuthenticator{Audience: audience, Allowed: allowed})
+	}
+	if v := os.Getenv("SHOVEL_API_KEYS"); v != "" {
+		keys, err := ParseAPIKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// Authenticators tries each authenticator in turn. A request is rejected as
+// soon as one of them finds credentials that turn out to be invalid.
+type Authenticators []Authenticator
+
+// Authenticate returns the principal of the first authenticator that accepts the request
+func (c Authenticators) Authenticate(r *http.Request) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrUnauthenticated) {
+			continue
+		}
+		return p, err
+	}
+	return Principal{}, ErrUnauthenticated
+}
+
+// validateIDToken verifies a Google-signed ID token
+var validateIDToken = idtoken.Validate
+
+// IDTokenAuthenticator accepts Google-signed ID tokens sent as bearer tokens
+type IDTokenAuthenticator struct {
+	Audience string   // Expected aud claim, usually the service URL
+	Allowed  []string // Email patterns (path.Match syntax) that may call; empty allows all
+}
+
+// Authenticate validates the bearer token of r
+func (a *IDTokenAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	payload, err := validateIDToken(r.Context(), token, a.Audience)
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	name := payload.Subject
+	if email, _ := payload.Claims["email"].(string); email != "" {
+		if verified, ok := payload.Claims["email_verified"].(bool); ok && !verified {
+			return Principal{}, fmt.Errorf("email %s is not verified", email)
+		}
+		name = email
+	}
+	if len(a.Allowed) > 0 && !matchesAny(a.Allowed, name) {
+		return Principal{}, fmt.Errorf("%s is not allowed to use the shovel", name)
+	}
+	return Principal{Kind: PrincipalIDToken, Name: name}, nil
+}
+
+// APIKeys accepts static keys sent in the X-API-Key header. It maps keys to their names.
+type APIKeys map[string]string
+
+// ParseAPIKeys parses comma-separated name:key pairs
+func ParseAPIKeys(spec string) (APIKeys, error) {
+	keys := APIKeys{}
+	for _, pair := range strings.Split(spec, ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("API keys must be name:key pairs")
+		}
+		keys[key] = name
+	}
+	return keys, nil
+}
+
+// Authenticate looks up the API key of r
+func (k APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	given := r.Header.Get(APIKeyHeader)
+	if given == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	for key, name := range k {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(given)) == 1 {
+			return Principal{Kind: PrincipalAPIKey, Name: name}, nil
+		}
+	}
+	return Principal{}, fmt.Errorf("invalid API key")
+}
+
+// denyAll rejects every request. It replaces a broken configuration so that
+// a typo cannot silently disable authentication.
+type denyAll struct{}
+
+func (denyAll) Authenticate(*http.Request) (Principal, error) {
+	return Principal{}, fmt.Errorf("authentication is misconfigured")
+}
+
+// matchesAny reports whether name matches one of the patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// withPrincipal returns a copy of ctx carrying p
+func withPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal of the request ctx belongs to
+func PrincipalFromContext(ctx context.Context) Principal {
+	if p, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return p
+	}
+	return Principal{Kind: PrincipalAnonymous}
+}
+
+// authenticate establishes the principal of r, responding with 401 when the
+// request is rejected. The returned request carries the principal.
+func authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if authenticator == nil {
+		return r, true
+	}
+	p, err := authenticator.Authenticate(r)
+	if err != nil {
+		logger.Warn("Rejected unauthenticated request", "path", r.URL.Path, "error", err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pubsub-shovel"`)
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return r, false
+	}
+	return r.WithContext(withPrincipal(r.Context(), p)), true
+}
@@ -0,0 +1,255 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/idtoken"
+)
+
+// useAuthenticator installs a as the authenticator for the rest of the test
+func useAuthenticator(t *testing.T, a Authenticator) {
+	t.Helper()
+	original := authenticator
+	SetAuthenticator(a)
+	t.Cleanup(func() { SetAuthenticator(original) })
+}
+
+// stubIDTokens makes validateIDToken accept "good-token" with the given claims
+func stubIDTokens(t *testing.T, claims map[string]interface{}) {
+	t.Helper()
+	original := validateIDToken
+	validateIDToken = func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+		if token != "good-token" || audience != "https://shovel.test" {
+			return nil, errors.New("invalid token")
+		}
+		return &idtoken.Payload{Audience: audience, Subject: "1234567890", Claims: claims}, nil
+	}
+	t.Cleanup(func() { validateIDToken = original })
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expected    APIKeys
+		expectError bool
+	}{
+		{spec: "ops:secret", expected: APIKeys{"secret": "ops"}},
+		{spec: "ops:secret, ci:other", expected: APIKeys{"secret": "ops", "other": "ci"}},
+		{spec: "secret", expectError: true},
+		{spec: "ops:", expectError: true},
+		{spec: ":secret", expectError: true},
+	}
+
+	for _, tt := range tests {
+		keys, err := ParseAPIKeys(tt.spec)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %q, got none", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected no error for %q, got %v", tt.spec, err)
+			continue
+		}
+		if len(keys) != len(tt.expected) {
+			t.Errorf("Expected %v, got %v", tt.expected, keys)
+		}
+		for key, name := range tt.expected {
+			if keys[key] != name {
+				t.Errorf("Expected key %s to belong to %s, got %s", key, name, keys[key])
+			}
+		}
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	stubIDTokens(t, map[string]interface{}{"email": "ci@test.iam.gserviceaccount.com", "email_verified": true})
+	chain := Authenticators{
+		&IDTokenAuthenticator{Audience: "https://shovel.test", Allowed: []string{"*@test.iam.gserviceaccount.com"}},
+		APIKeys{"secret": "ops"},
+	}
+
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		expected    Principal
+		expectError error
+	}{
+		{
+			name:     "ID token",
+			headers:  map[string]string{"Authorization": "Bearer good-token"},
+			expected: Principal{Kind: PrincipalIDToken, Name: "ci@test.iam.gserviceaccount.com"},
+		},
+		{
+			name:     "API key",
+			headers:  map[string]string{APIKeyHeader: "secret"},
+			expected: Principal{Kind: PrincipalAPIKey, Name: "ops"},
+		},
+		{
+			name:        "invalid ID token",
+			headers:     map[string]string{"Authorization": "Bearer bad-token", APIKeyHeader: "secret"},
+			expectError: errors.New("invalid ID token"),
+		},
+		{
+			name:        "invalid API key",
+			headers:     map[string]string{APIKeyHeader: "wrong"},
+			expectError: errors.New("invalid API key"),
+		},
+		{
+			name:        "no credentials",
+			headers:     map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			expectError: ErrUnauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/jobs", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			p, err := chain.Authenticate(req)
+			if tt.expectError != nil {
+				if err == nil {
+					t.Fatalf("Expected error, got principal %v", p)
+				}
+				if errors.Is(tt.expectError, ErrUnauthenticated) != errors.Is(err, ErrUnauthenticated) {
+					t.Errorf("Expected %v, got %v", tt.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if p != tt.expected {
+				t.Errorf("Expected principal %v, got %v", tt.expected, p)
+			}
+		})
+	}
+}
+
+func TestIDTokenAuthenticator_Claims(t *testing.T) {
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		allowed     []string
+		expected    string
+		expectError bool
+	}{
+		{name: "subject without email", claims: map[string]interface{}{}, expected: "1234567890"},
+		{name: "unverified email", claims: map[string]interface{}{"email": "a@example.com", "email_verified": false}, expectError: true},
+		{name: "email not allowed", claims: map[string]interface{}{"email": "a@example.com", "email_verified": true}, allowed: []string{"*@test.iam.gserviceaccount.com"}, expectError: true},
+		{name: "email allowed", claims: map[string]interface{}{"email": "a@example.com", "email_verified": true}, allowed: []string{"b@example.com", "a@example.com"}, expected: "a@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubIDTokens(t, tt.claims)
+			a := &IDTokenAuthenticator{Audience: "https://shovel.test", Allowed: tt.allowed}
+			req := httptest.NewRequest("GET", "/jobs", nil)
+			req.Header.Set("Authorization", "Bearer good-token")
+
+			p, err := a.Authenticate(req)
+			if tt.expectError {
+				if err == nil || errors.Is(err, ErrUnauthenticated) {
+					t.Errorf("Expected rejection, got %v, %v", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if p.Name != tt.expected {
+				t.Errorf("Expected principal name %s, got %s", tt.expected, p.Name)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticatorFromEnv(t *testing.T) {
+	a, err := NewAuthenticatorFromEnv()
+	if err != nil || a != nil {
+		t.Errorf("Expected authentication to be disabled without configuration, got %v, %v", a, err)
+	}
+
+	t.Setenv("SHOVEL_API_KEYS", "broken")
+	if _, err := NewAuthenticatorFromEnv(); err == nil {
+		t.Error("Expected error for malformed API keys")
+	}
+
+	t.Setenv("SHOVEL_API_KEYS", "ops:secret")
+	t.Setenv("SHOVEL_AUTH_AUDIENCE", "https://shovel.test")
+	a, err = NewAuthenticatorFromEnv()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if chain, ok := a.(Authenticators); !ok || len(chain) != 2 {
+		t.Errorf("Expected ID token and API key authenticators, got %#v", a)
+	}
+}
+
+func TestHandlers_RequireAuthentication(t *testing.T) {
+	useAuthenticator(t, APIKeys{"secret": "ops"})
+	job := newJob("shovel-auth-test", ShovelRequest{TargetTopic: "projects/test/topics/target"})
+	jobs.add(job)
+	defer job.finish(nil)
+
+	handlers := map[string]http.HandlerFunc{
+		"Handler":       Handler,
+		"StatusHandler": StatusHandler,
+		"CancelHandler": CancelHandler,
+		"ListHandler":   ListHandler,
+		"StreamHandler": StreamHandler,
+	}
+	for name, handler := range handlers {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", "/?jobId="+job.ID, nil))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s to return %d, got %d", name, http.StatusUnauthorized, rr.Code)
+		}
+		if rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected %s to set WWW-Authenticate", name)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?jobId="+job.ID, nil)
+	req.Header.Set(APIKeyHeader, "secret")
+	StatusHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d with a valid key, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestAuthenticate_RecordsPrincipal(t *testing.T) {
+	useAuthenticator(t, APIKeys{"secret": "ops"})
+	req := httptest.NewRequest("POST", "/jobs", nil)
+	req.Header.Set(APIKeyHeader, "secret")
+
+	r, ok := authenticate(httptest.NewRecorder(), req)
+	if !ok {
+		t.Fatal("Expected request to be authenticated")
+	}
+	p := PrincipalFromContext(r.Context())
+	if p.String() != "apikey:ops" {
+		t.Errorf("Expected principal apikey:ops, got %s", p)
+	}
+
+	job := newJob("shovel-principal-test", ShovelRequest{})
+	job.Principal = p
+	if status := job.Status(); status.Principal != "apikey:ops" {
+		t.Errorf("Expected principal in status, got %q", status.Principal)
+	}
+	if record := job.Record(); record.Principal != p {
+		t.Errorf("Expected principal in record, got %v", record.Principal)
+	}
+
+	if anon := PrincipalFromContext(context.Background()); anon.Kind != PrincipalAnonymous {
+		t.Errorf("Expected anonymous principal without authentication, got %v", anon)
+	}
+}
@@ -0,0 +1,84 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+)
+
+// clientCache keeps one Pub/Sub client per project and identity. Clients are
+// safe for concurrent use and expensive to set up, so jobs share them.
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[clientKey]*pubsub.Client
+}
+
+// clientKey identifies a cached client
+type clientKey struct {
+	project        string
+	serviceAccount string // Impersonated service account, empty for the default credentials
+}
+
+// pubsubClients is shared by all jobs and operations of the process
+var pubsubClients = &clientCache{}
+
+// get returns the client for project acting as serviceAccount, creating it
+// on first use. An empty serviceAccount uses the default credentials.
+func (c *clientCache) get(ctx context.Context, project, serviceAccount string) (*pubsub.Client, error) {
+	key := clientKey{project: project, serviceAccount: serviceAccount}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+
+	// The client outlives the job that happens to create it
+	ctx = context.WithoutCancel(ctx)
+	var opts []option.ClientOption
+	if serviceAccount != "" {
+		ts, err := newImpersonatedTokenSource(ctx, serviceAccount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to impersonate %s: %v", serviceAccount, err)
+		}
+		opts = append(opts, option.WithTokenSource(ts))
+	}
+	client, err := newPubsubClient(ctx, project, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pubsub client for project %s as %s: %v", project, identity(serviceAccount), err)
+	}
+	if c.clients == nil {
+		c.clients = map[clientKey]*pubsub.Client{}
+	}
+	c.clients[key] = client
+	return client, nil
+}
+
+// closeAll closes and forgets all clients
+func (c *clientCache) closeAll() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for key, client := range c.clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close pubsub client for project %s: %v", key.project, err))
+		}
+	}
+	c.clients = nil
+	return errors.Join(errs...)
+}
+
+// pubsubClient returns the shared client for project acting as
+// serviceAccount, or with the default credentials when it is empty
+func pubsubClient(ctx context.Context, project, serviceAccount string) (*pubsub.Client, error) {
+	return pubsubClients.get(ctx, project, serviceAccount)
+}
+
+// CloseClients closes the Pub/Sub clients shared by the jobs. Call it once
+// all jobs finished.
+func CloseClients() error {
+	return pubsubClients.closeAll()
+}
@@ -0,0 +1,148 @@
+package shovel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+)
+
+func TestClientCache(t *testing.T) {
+	srv, _ := newTestClient(t)
+	useTestServer(t, srv)
+
+	a, err := pubsubClient(context.Background(), "project-a", "")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	again, _ := pubsubClient(context.Background(), "project-a", "")
+	b, _ := pubsubClient(context.Background(), "project-b", "")
+	if a != again {
+		t.Error("Expected the client of a project to be reused")
+	}
+	if a == b || b.Project() != "project-b" {
+		t.Errorf("Expected a separate client for project-b, got one for %s", b.Project())
+	}
+
+	// A cancelled job context must not break the shared client
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _ := pubsubClient(ctx, "project-c", "")
+	cancel()
+	if _, err := c.CreateTopic(context.Background(), "still-usable"); err != nil {
+		t.Errorf("Expected client to outlive the context that created it, got %v", err)
+	}
+
+	if err := CloseClients(); err != nil {
+		t.Fatalf("Failed to close clients: %v", err)
+	}
+	fresh, _ := pubsubClient(context.Background(), "project-a", "")
+	if fresh == a {
+		t.Error("Expected a new client after closing")
+	}
+}
+
+func TestShovel_CrossProject(t *testing.T) {
+	ctx := context.Background()
+	srv, _ := newTestClient(t)
+	useTestServer(t, srv)
+
+	var mu sync.Mutex
+	created := map[string]int{}
+	factory := newPubsubClient
+	newPubsubClient = func(ctx context.Context, projectID string, opts ...option.ClientOption) (*pubsub.Client, error) {
+		mu.Lock()
+		created[projectID]++
+		mu.Unlock()
+		return factory(ctx, projectID, opts...)
+	}
+	t.Cleanup(func() { newPubsubClient = factory })
+
+	// The source project has a topic with the same ID as the target, which
+	// must stay untouched
+	setup := func(project string) *pubsub.Client {
+		client, err := factory(ctx, project)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	sourceClient := setup("source-project")
+	targetClient := setup("target-project")
+
+	source, err := sourceClient.CreateTopic(ctx, "source")
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	t.Cleanup(source.Stop)
+	if _, err := sourceClient.CreateSubscription(ctx, "source-sub", pubsub.SubscriptionConfig{Topic: source}); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	decoy, err := sourceClient.CreateTopic(ctx, "orders")
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	decoySub, err := sourceClient.CreateSubscription(ctx, "decoy-sink", pubsub.SubscriptionConfig{Topic: decoy})
+	if err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	target, err := targetClient.CreateTopic(ctx, "orders")
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	targetSub, err := targetClient.CreateSubscription(ctx, "sink", pubsub.SubscriptionConfig{Topic: target})
+	if err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := source.Publish(ctx, &pubsub.Message{Data: []byte("order")}).Get(ctx); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	job := newJob("shovel-cross-project-test", ShovelRequest{
+		Mode:               ModeContinuous,
+		SourceSubscription: "projects/source-project/subscriptions/source-sub",
+		TargetTopic:        "projects/target-project/topics/orders",
+	})
+	jobs.add(job)
+	job.start(ctx)
+	waitFor(t, "messages to be processed", func() bool { return job.Status().ProcessedCount == 5 })
+	job.Cancel()
+	<-job.Done()
+
+	if got := countMessages(t, targetSub); got != 5 {
+		t.Errorf("Expected 5 messages in the target project, got %d", got)
+	}
+	if got := countMessages(t, decoySub); got != 0 {
+		t.Errorf("Expected no messages in the source project's topic of the same name, got %d", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if created["source-project"] != 1 || created["target-project"] != 1 {
+		t.Errorf("Expected one client per project, got %v", created)
+	}
+}
+
+// countMessages receives from sub until it stays idle and returns the number of messages
+func countMessages(t *testing.T, sub *pubsub.Subscription) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var mu sync.Mutex
+	count := 0
+	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		msg.Ack()
+	})
+	if err != nil {
+		t.Fatalf("Failed to receive: %v", err)
+	}
+	return count
+}
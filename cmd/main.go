@@ -1,11 +1,11 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
-	_ "github.com/torbendury/pubsub-shovel"
+	shovel "github.com/torbendury/pubsub-shovel"
 )
 
 func main() {
@@ -19,12 +19,14 @@ func main() {
 		port = p
 	}
 
-	log.Printf("Starting server on port %s", port)
-	log.Printf("Function available at:")
-	log.Printf("  http://localhost:%s/Handler", port)
+	// Nack in-flight messages of running jobs on Ctrl-C
+	shovel.ShutdownOnSignal(shovel.ShutdownTimeout)
+
+	slog.Info("Starting server", "port", port, "url", "http://localhost:"+port+"/Handler")
 
 	if err := funcframework.Start(port); err != nil {
-		log.Fatalf("funcframework.Start: %v\n", err)
+		slog.Error("funcframework.Start failed", "error", err)
+		os.Exit(1)
 	}
 
 	//if err := http.ListenAndServe(":"+port, nil); err != nil {
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	shovel "github.com/torbendury/pubsub-shovel"
+)
+
+func main() {
+	// Default listen address honours PORT like the Cloud Functions runtime
+	defaultAddr := ":8080"
+	if p := os.Getenv("PORT"); p != "" {
+		defaultAddr = ":" + p
+	}
+
+	addr := flag.String("addr", defaultAddr, "Listen address")
+	tlsCert := flag.String("tls-cert", os.Getenv("SHOVEL_TLS_CERT"), "TLS certificate file (or set SHOVEL_TLS_CERT)")
+	tlsKey := flag.String("tls-key", os.Getenv("SHOVEL_TLS_KEY"), "TLS private key file (or set SHOVEL_TLS_KEY)")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Minute, "How long to wait for running jobs on shutdown")
+	jobStore := flag.String("job-store", os.Getenv("SHOVEL_JOB_STORE"), "Job store: memory or file:DIR (or set SHOVEL_JOB_STORE)")
+	resume := flag.Bool("resume", true, "Resume interrupted continuous and drain jobs from the job store")
+	flag.Parse()
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal("Both -tls-cert and -tls-key are required to enable TLS", nil)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	store, err := shovel.NewJobStore(*jobStore)
+	if err != nil {
+		fatal("Failed to open job store", err)
+	}
+	shovel.SetJobStore(store)
+
+	if *resume {
+		resumed, err := shovel.ResumeJobs(context.Background())
+		if err != nil {
+			fatal("Failed to resume jobs", err)
+		}
+		slog.Info("Resumed jobs", "count", len(resumed))
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           shovel.NewServeMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	// Progress streams of continuous jobs would hold up Shutdown until the drain timeout
+	srv.RegisterOnShutdown(shovel.CloseStreams)
+
+	// Start the server
+	go func() {
+		var err error
+		if *tlsCert != "" {
+			slog.Info("Starting TLS server", "addr", *addr)
+			err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			slog.Info("Starting server", "addr", *addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Server failed", err)
+		}
+	}()
+
+	// Wait for termination signal
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-stop
+	slog.Info("Received signal, shutting down", "signal", sig.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+
+	// Stop accepting requests, then let the running jobs finish
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Failed to shut down server", "error", err)
+	}
+	if err := shovel.DrainJobs(ctx); err != nil {
+		slog.Error("Failed to drain jobs", "error", err)
+	}
+	if err := shovel.FlushNotifications(ctx); err != nil {
+		slog.Error("Failed to deliver notifications", "error", err)
+	}
+	if err := shovel.FlushAudit(ctx); err != nil {
+		slog.Error("Failed to write audit records", "error", err)
+	}
+	if err := shovel.CloseClients(); err != nil {
+		slog.Warn("Failed to close Pub/Sub clients", "error", err)
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs msg with err at error severity and exits
+func fatal(msg string, err error) {
+	if err != nil {
+		slog.Error(msg, "error", err)
+	} else {
+		slog.Error(msg)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	shovel "github.com/torbendury/pubsub-shovel"
+)
+
+const usage = `Usage: shovel <command> [flags]
+
+Commands:
+  move     Move messages from a subscription to a topic
+  peek     Show messages of a subscription without consuming them
+  export   Write messages of a subscription to a JSON lines file
+  import   Publish messages from a JSON lines file to a topic
+  status   Show the status of a job
+  cancel   Cancel a running job
+  list     List jobs
+
+move runs in-process with local credentials unless -endpoint (or
+SHOVEL_ENDPOINT) points to a shovel server. status, cancel and list always
+talk to a server. Run "shovel <command> -h" for the flags of a command.
+
+Servers with authentication enabled need SHOVEL_ID_TOKEN (e.g. from
+"gcloud auth print-identity-token") or SHOVEL_API_KEY.
+`
+
+// commands maps subcommand names to their implementation
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"move":   runMove,
+	"peek":   runPeek,
+	"export": runExport,
+	"import": runImport,
+	"status": runStatus,
+	"cancel": runCancel,
+	"list":   runList,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	// Log in-process operations as readable text unless asked otherwise
+	if os.Getenv("LOG_FORMAT") == "" {
+		shovel.SetLogger(shovel.NewLogger(os.Stderr, "text", os.Getenv("LOG_LEVEL")))
+	}
+
+	// Cancel the running operation on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := cmd(ctx, os.Args[2:])
+	// Notifications and audit records of in-process jobs are sent in the background
+	flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if flushErr := shovel.FlushNotifications(flushCtx); flushErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", flushErr)
+	}
+	if flushErr := shovel.FlushAudit(flushCtx); flushErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", flushErr)
+	}
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// endpointFlag registers the -endpoint flag shared by the remote commands
+func endpointFlag(fs *flag.FlagSet) *string {
+	return fs.String("endpoint", os.Getenv("SHOVEL_ENDPOINT"), "Shovel server base URL (or set SHOVEL_ENDPOINT)")
+}
+
+// runMove starts a shovel job, either remotely or in-process
+func runMove(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("move", flag.ExitOnError)
+	from := fs.String("from", "", "Source subscription (projects/P/subscriptions/S)")
+	to := fs.String("to", "", "Target topic (projects/P/topics/T)")
+	num := fs.Int("n", 0, "Maximum number of messages to move")
+	all := fs.Bool("all", false, "Move all available messages")
+	continuous := fs.Bool("continuous", false, "Keep forwarding until cancelled")
+	endpoint := endpointFlag(fs)
+	wait := fs.Bool("wait", false, "With -endpoint, wait for the job to finish")
+	jsonOut := fs.Bool("json", false, "Print JSON output")
+	fs.Parse(args)
+
+	req := shovel.ShovelRequest{
+		NumMessages:        *num,
+		AllMessages:        *all,
+		SourceSubscription: *from,
+		TargetTopic:        *to,
+	}
+	if *continuous {
+		req.Mode = shovel.ModeContinuous
+	}
+
+	if *endpoint == "" {
+		status, err := shovel.Run(ctx, req, 2*time.Second, func(s shovel.JobStatus) {
+			if !*jsonOut {
+				printProgress(s)
+			}
+		})
+		if err != nil && status.ID == "" {
+			return err
+		}
+		printStatus(status, *jsonOut)
+		return err
+	}
+
+	client := newRemoteClient(*endpoint)
+	resp, err := client.createJob(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !*wait {
+		if *jsonOut {
+			return printJSON(resp)
+		}
+		fmt.Printf("Started job %s\n", resp.RequestID)
+		return nil
+	}
+
+	status, err := client.waitForJob(ctx, resp.RequestID, 2*time.Second, func(s shovel.JobStatus) {
+		if !*jsonOut {
+			printProgress(s)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	printStatus(status, *jsonOut)
+	if status.State == shovel.JobStateFailed {
+		return fmt.Errorf("job failed: %s", status.Error)
+	}
+	return nil
+}
+
+// runPeek prints messages without consuming them
+func runPeek(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("peek", flag.ExitOnError)
+	from := fs.String("from", "", "Subscription to peek into")
+	num := fs.Int("n", 10, "Maximum number of messages to show")
+	jsonOut := fs.Bool("json", false, "Print JSON output")
+	fs.Parse(args)
+
+	if *from == "" {
+		return fmt.Errorf("-from is required")
+	}
+	records, err := shovel.Peek(ctx, *from, *num)
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		return printJSON(records)
+	}
+	for _, r := range records {
+		fmt.Printf("--- %s (published %s)\n", r.ID, r.PublishTime.Format(time.RFC3339))
+		if r.OrderingKey != "" {
+			fmt.Printf("ordering key: %s\n", r.OrderingKey)
+		}
+		for k, v := range r.Attributes {
+			fmt.Printf("%s: %s\n", k, v)
+		}
+		fmt.Printf("\n%s\n", r.Data)
+	}
+	fmt.Fprintf(os.Stderr, "%d messages\n", len(records))
+	return nil
+}
+
+// runExport writes messages to a JSON lines file
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	from := fs.String("from", "", "Subscription to export from")
+	num := fs.Int("n", 1000, "Maximum number of messages to export")
+	ack := fs.Bool("ack", false, "Acknowledge exported messages, removing them from the subscription")
+	out := fs.String("o", "-", "Output file, - for stdout")
+	fs.Parse(args)
+
+	if *from == "" {
+		return fmt.Errorf("-from is required")
+	}
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	count, err := shovel.Export(ctx, *from, *num, *ack, w)
+	fmt.Fprintf(os.Stderr, "Exported %d messages\n", count)
+	return err
+}
+
+// runImport publishes messages from a JSON lines file
+func runImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	to := fs.String("to", "", "Topic to publish to")
+	in := fs.String("i", "-", "Input file, - for stdin")
+	fs.Parse(args)
+
+	if *to == "" {
+		return fmt.Errorf("-to is required")
+	}
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	count, err := shovel.Import(ctx, *to, r)
+	fmt.Fprintf(os.Stderr, "Imported %d messages\n", count)
+	return err
+}
+
+// runStatus prints the status of a remote job
+func runStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	endpoint := endpointFlag(fs)
+	jsonOut := fs.Bool("json", false, "Print JSON output")
+	fs.Parse(args)
+
+	if *endpoint == "" || fs.NArg() != 1 {
+		return fmt.Errorf("usage: shovel status -endpoint URL JOB_ID")
+	}
+	status, err := newRemoteClient(*endpoint).getJob(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	printStatus(status, *jsonOut)
+	return nil
+}
+
+// runCancel cancels a remote job
+func runCancel(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("cancel", flag.ExitOnError)
+	endpoint := endpointFlag(fs)
+	jsonOut := fs.Bool("json", false, "Print JSON output")
+	fs.Parse(args)
+
+	if *endpoint == "" || fs.NArg() != 1 {
+		return fmt.Errorf("usage: shovel cancel -endpoint URL JOB_ID")
+	}
+	resp, err := newRemoteClient(*endpoint).cancelJob(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		return printJSON(resp)
+	}
+	fmt.Printf("Cancelling job %s\n", resp.RequestID)
+	return nil
+}
+
+// runList prints all remote jobs
+func runList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	endpoint := endpointFlag(fs)
+	state := fs.String("state", "", "Only jobs in this state")
+	source := fs.String("source", "", "Only jobs reading from this subscription")
+	target := fs.String("target", "", "Only jobs publishing to this topic")
+	since := fs.Duration("since", 0, "Only jobs started within this duration, e.g. 24h")
+	jsonOut := fs.Bool("json", false, "Print JSON output")
+	fs.Parse(args)
+
+	if *endpoint == "" {
+		return fmt.Errorf("usage: shovel list -endpoint URL")
+	}
+	query := url.Values{}
+	query.Set("pageSize", "500")
+	if *state != "" {
+		query.Set("state", *state)
+	}
+	if *source != "" {
+		query.Set("sourceSubscription", *source)
+	}
+	if *target != "" {
+		query.Set("targetTopic", *target)
+	}
+	if *since > 0 {
+		query.Set("startedAfter", time.Now().Add(-*since).Format(time.RFC3339))
+	}
+
+	summaries, err := newRemoteClient(*endpoint).listJobs(ctx, query)
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		return printJSON(summaries)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tMODE\tPROCESSED\tFAILED\tDURATION\tMSG/S\tSOURCE\tTARGET")
+	for _, s := range summaries {
+		duration := time.Duration(s.DurationSeconds * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%.1f\t%s\t%s\n", s.ID, s.State, s.Mode, s.ProcessedCount, s.FailedCount, duration, s.Throughput, s.SourceSubscription, s.TargetTopic)
+	}
+	return tw.Flush()
+}
+
+// printProgress writes a single progress line to stderr
+func printProgress(s shovel.JobStatus) {
+	fmt.Fprintf(os.Stderr, "%s: %s, processed %d, failed %d, in flight %d\n", s.ID, s.State, s.ProcessedCount, s.FailedCount, s.InFlight)
+}
+
+// printStatus prints a job status as JSON or human-readable text
+func printStatus(s shovel.JobStatus, jsonOut bool) {
+	if jsonOut {
+		printJSON(s)
+		return
+	}
+	fmt.Printf("Job:       %s\n", s.ID)
+	fmt.Printf("State:     %s\n", s.State)
+	fmt.Printf("Mode:      %s\n", s.Mode)
+	fmt.Printf("Source:    %s\n", s.SourceSubscription)
+	fmt.Printf("Target:    %s\n", s.TargetTopic)
+	fmt.Printf("Processed: %d (accepted %d, failed %d)\n", s.ProcessedCount, s.AcceptedCount, s.FailedCount)
+	fmt.Printf("Started:   %s\n", s.StartedAt.Format(time.RFC3339))
+	if s.FinishedAt != nil {
+		fmt.Printf("Finished:  %s (%s)\n", s.FinishedAt.Format(time.RFC3339), s.FinishedAt.Sub(s.StartedAt).Round(time.Second))
+	}
+	if s.Error != "" {
+		fmt.Printf("Error:     %s\n", strings.TrimSpace(s.Error))
+	}
+}
+
+// printJSON writes v as indented JSON to stdout
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	shovel "github.com/torbendury/pubsub-shovel"
+)
+
+// remoteClient talks to the job routes of a shovel server
+type remoteClient struct {
+	endpoint string
+	http     *http.Client
+}
+
+// newRemoteClient creates a client for the server at endpoint
+func newRemoteClient(endpoint string) *remoteClient {
+	return &remoteClient{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// createJob submits a shovel request
+func (c *remoteClient) createJob(ctx context.Context, req shovel.ShovelRequest) (shovel.ShovelResponse, error) {
+	var resp shovel.ShovelResponse
+	err := c.do(ctx, "POST", "/jobs", req, &resp)
+	return resp, err
+}
+
+// getJob fetches the status of a job
+func (c *remoteClient) getJob(ctx context.Context, id string) (shovel.JobStatus, error) {
+	var status shovel.JobStatus
+	err := c.do(ctx, "GET", "/jobs/"+url.PathEscape(id), nil, &status)
+	return status, err
+}
+
+// cancelJob requests cancellation of a job
+func (c *remoteClient) cancelJob(ctx context.Context, id string) (shovel.ShovelResponse, error) {
+	var resp shovel.ShovelResponse
+	err := c.do(ctx, "POST", "/jobs/"+url.PathEscape(id)+"/cancel", nil, &resp)
+	return resp, err
+}
+
+// listJobs fetches all jobs matching query, following pagination
+func (c *remoteClient) listJobs(ctx context.Context, query url.Values) ([]shovel.JobSummary, error) {
+	var summaries []shovel.JobSummary
+	for {
+		var page shovel.JobList
+		if err := c.do(ctx, "GET", "/jobs?"+query.Encode(), nil, &page); err != nil {
+			return summaries, err
+		}
+		summaries = append(summaries, page.Jobs...)
+		if page.NextPageToken == "" {
+			return summaries, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// waitForJob polls a job until it is no longer running
+func (c *remoteClient) waitForJob(ctx context.Context, id string, interval time.Duration, progress func(shovel.JobStatus)) (shovel.JobStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := c.getJob(ctx, id)
+		if err != nil {
+			return status, err
+		}
+		if status.State != shovel.JobStateRunning {
+			return status, nil
+		}
+		progress(status)
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *remoteClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("SHOVEL_ID_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if key := os.Getenv("SHOVEL_API_KEY"); key != "" {
+		req.Header.Set(shovel.APIKeyHeader, key)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var errResp shovel.ShovelResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Message != "" {
+			return fmt.Errorf("%s %s: %s (%d)", method, path, errResp.Message, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: unexpected status %d", method, path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
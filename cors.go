@@ -0,0 +1,168 @@
+package shovel
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call the job endpoints
+type CORSConfig struct {
+	AllowedOrigins   []string      // Exact origins or patterns like "https://*.example.com"; "*" allows every origin
+	AllowedMethods   []string      // Methods announced on preflight; empty announces the methods of each endpoint
+	AllowedHeaders   []string      // Request headers browsers may send
+	AllowCredentials bool          // Allow cookies and Authorization headers to be sent cross-origin, requires listed origins
+	MaxAge           time.Duration // How long browsers may cache preflight results, 0 leaves it to the browser
+}
+
+// DefaultCORSConfig allows every origin without credentials
+func DefaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", APIKeyHeader},
+	}
+}
+
+// cors is applied by all job endpoints
+var cors = DefaultCORSConfig()
+
+func init() {
+	c, err := CORSConfigFromEnv()
+	if err != nil {
+		logger.Error("Failed to configure CORS, rejecting all cross-origin requests", "error", err)
+		c = CORSConfig{}
+	}
+	cors = c
+}
+
+// SetCORSConfig replaces the CORS configuration
+func SetCORSConfig(c CORSConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	cors = c
+	return nil
+}
+
+// validate rejects credentials for every origin: any website could then make
+// calls in the name of the shovel's users
+func (c CORSConfig) validate() error {
+	if !c.AllowCredentials {
+		return nil
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return fmt.Errorf("credentials require explicitly listed origins, not *")
+		}
+	}
+	return nil
+}
+
+// CORSConfigFromEnv starts from DefaultCORSConfig and applies
+// SHOVEL_CORS_ORIGINS, SHOVEL_CORS_METHODS and SHOVEL_CORS_HEADERS
+// (comma-separated), SHOVEL_CORS_CREDENTIALS (true or false) and
+// SHOVEL_CORS_MAX_AGE (a duration like 10m)
+func CORSConfigFromEnv() (CORSConfig, error) {
+	c := DefaultCORSConfig()
+	if v := os.Getenv("SHOVEL_CORS_ORIGINS"); v != "" {
+		c.AllowedOrigins = splitList(v)
+		for _, origin := range c.AllowedOrigins {
+			if _, err := path.Match(origin, ""); err != nil {
+				return c, fmt.Errorf("invalid origin pattern %q", origin)
+			}
+		}
+	}
+	if v := os.Getenv("SHOVEL_CORS_METHODS"); v != "" {
+		c.AllowedMethods = splitList(v)
+	}
+	if v := os.Getenv("SHOVEL_CORS_HEADERS"); v != "" {
+		c.AllowedHeaders = splitList(v)
+	}
+	if v := os.Getenv("SHOVEL_CORS_CREDENTIALS"); v != "" {
+		credentials, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid SHOVEL_CORS_CREDENTIALS: %v", err)
+		}
+		c.AllowCredentials = credentials
+	}
+	if v := os.Getenv("SHOVEL_CORS_MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil || maxAge < 0 {
+			return c, fmt.Errorf("invalid SHOVEL_CORS_MAX_AGE %q", v)
+		}
+		c.MaxAge = maxAge
+	}
+	return c, c.validate()
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for origin, or
+// an empty string when origin may not call the shovel
+func (c CORSConfig) allowedOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+	}
+	if origin != "" && matchesAny(c.AllowedOrigins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// handleCORS sets the CORS headers for r and answers preflight requests.
+// methods are the methods of the endpoint. It reports whether r was a
+// preflight request, in which case the response has been written.
+func handleCORS(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	c := cors
+	allowOrigin := c.allowedOrigin(r.Header.Get("Origin"))
+	if allowOrigin != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+	if allowOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		// Never with a wildcard, credentials are for listed origins only
+		if c.AllowCredentials && allowOrigin != "*" {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	if r.Method != http.MethodOptions {
+		return false
+	}
+
+	if allowOrigin != "" {
+		if len(c.AllowedMethods) > 0 {
+			methods = c.AllowedMethods
+		} else {
+			methods = append(methods, http.MethodOptions)
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// PreflightHandler answers preflight requests for endpoints accepting methods
+func PreflightHandler(methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleCORS(w, r, methods...)
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
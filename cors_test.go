@@ -0,0 +1,179 @@
+package shovel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useCORSConfig installs c for the rest of the test
+func useCORSConfig(t *testing.T, c CORSConfig) {
+	t.Helper()
+	original := cors
+	if err := SetCORSConfig(c); err != nil {
+		t.Fatalf("Failed to set CORS config: %v", err)
+	}
+	t.Cleanup(func() { cors = original })
+}
+
+func TestHandleCORS(t *testing.T) {
+	console := CORSConfig{
+		AllowedOrigins:   []string{"https://console.example.com", "https://*.preview.example.com"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+
+	tests := []struct {
+		name            string
+		config          CORSConfig
+		method          string
+		origin          string
+		expectedOrigin  string
+		expectedMethods string
+		expectedMaxAge  string
+		expectPreflight bool
+	}{
+		{
+			name:            "default allows every origin",
+			config:          DefaultCORSConfig(),
+			method:          "OPTIONS",
+			origin:          "https://anywhere.example.org",
+			expectedOrigin:  "*",
+			expectedMethods: "GET, OPTIONS",
+			expectPreflight: true,
+		},
+		{
+			name:            "listed origin",
+			config:          console,
+			method:          "OPTIONS",
+			origin:          "https://console.example.com",
+			expectedOrigin:  "https://console.example.com",
+			expectedMethods: "GET, OPTIONS",
+			expectedMaxAge:  "600",
+			expectPreflight: true,
+		},
+		{
+			name:           "origin pattern",
+			config:         console,
+			method:         "GET",
+			origin:         "https://pr-42.preview.example.com",
+			expectedOrigin: "https://pr-42.preview.example.com",
+		},
+		{
+			name:            "unknown origin",
+			config:          console,
+			method:          "OPTIONS",
+			origin:          "https://console.example.com.evil.test",
+			expectPreflight: true,
+		},
+		{
+			name:            "configured methods",
+			config:          CORSConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET", "POST", "OPTIONS"}},
+			method:          "OPTIONS",
+			expectedOrigin:  "*",
+			expectedMethods: "GET, POST, OPTIONS",
+			expectPreflight: true,
+		},
+		{
+			name:           "credentials for listed origins only",
+			config:         console,
+			method:         "GET",
+			origin:         "https://evil.example.org",
+			expectedOrigin: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCORSConfig(t, tt.config)
+			req := httptest.NewRequest(tt.method, "/jobs", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rr := httptest.NewRecorder()
+
+			if preflight := handleCORS(rr, req, "GET"); preflight != tt.expectPreflight {
+				t.Errorf("Expected preflight %v, got %v", tt.expectPreflight, preflight)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.expectedOrigin {
+				t.Errorf("Expected origin %q, got %q", tt.expectedOrigin, got)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Methods"); got != tt.expectedMethods {
+				t.Errorf("Expected methods %q, got %q", tt.expectedMethods, got)
+			}
+			if got := rr.Header().Get("Access-Control-Max-Age"); got != tt.expectedMaxAge {
+				t.Errorf("Expected max age %q, got %q", tt.expectedMaxAge, got)
+			}
+			expectCredentials := tt.config.AllowCredentials && tt.expectedOrigin != ""
+			if got := rr.Header().Get("Access-Control-Allow-Credentials") == "true"; got != expectCredentials {
+				t.Errorf("Expected credentials %v, got %v", expectCredentials, got)
+			}
+			if tt.expectedOrigin != "*" && rr.Header().Get("Vary") != "Origin" {
+				t.Errorf("Expected Vary: Origin, got %q", rr.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCORSConfigFromEnv(t *testing.T) {
+	c, err := CORSConfigFromEnv()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(c.AllowedOrigins) != 1 || c.AllowedOrigins[0] != "*" {
+		t.Errorf("Expected all origins by default, got %v", c.AllowedOrigins)
+	}
+
+	t.Setenv("SHOVEL_CORS_ORIGINS", "https://console.example.com, https://*.example.com")
+	t.Setenv("SHOVEL_CORS_HEADERS", "Content-Type,Authorization")
+	t.Setenv("SHOVEL_CORS_CREDENTIALS", "true")
+	t.Setenv("SHOVEL_CORS_MAX_AGE", "1h")
+	c, err = CORSConfigFromEnv()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(c.AllowedOrigins) != 2 || c.AllowedOrigins[1] != "https://*.example.com" {
+		t.Errorf("Expected configured origins, got %v", c.AllowedOrigins)
+	}
+	if len(c.AllowedHeaders) != 2 || !c.AllowCredentials || c.MaxAge != time.Hour {
+		t.Errorf("Unexpected config %+v", c)
+	}
+
+	if err := SetCORSConfig(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("Expected credentials for every origin to be rejected")
+	}
+	t.Run("credentials without origins", func(t *testing.T) {
+		t.Setenv("SHOVEL_CORS_ORIGINS", "")
+		t.Setenv("SHOVEL_CORS_CREDENTIALS", "true")
+		if _, err := CORSConfigFromEnv(); err == nil {
+			t.Error("Expected credentials with the default origins to be rejected")
+		}
+	})
+
+	for env, value := range map[string]string{
+		"SHOVEL_CORS_ORIGINS":     "https://[",
+		"SHOVEL_CORS_CREDENTIALS": "sometimes",
+		"SHOVEL_CORS_MAX_AGE":     "-1m",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := CORSConfigFromEnv(); err == nil {
+				t.Errorf("Expected error for %s=%s", env, value)
+			}
+		})
+	}
+}
+
+func TestStatusHandler_PreflightSkipsAuthentication(t *testing.T) {
+	useAuthenticator(t, APIKeys{"secret": "ops"})
+	req := httptest.NewRequest("OPTIONS", "/jobs/shovel-1", nil)
+	req.Header.Set("Origin", "https://console.example.com")
+	rr := httptest.NewRecorder()
+
+	StatusHandler(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+	}
+}
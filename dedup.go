@@ -0,0 +1,566 @@
+package shovel
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Dedup keys
+const (
+	DedupKeyMessageID = "messageId" // ID of the original source message
+	DedupKeyPayload   = "payload"   // SHA-256 of the message data
+	// DedupKeyAttribute prefixes keys taken from an attribute, e.g. "attribute:eventId"
+	DedupKeyAttribute = "attribute:"
+)
+
+// SourceMessageIDAttribute carries the ID of the original source message on
+// messages moved by a job with dedup. A message that is moved again keeps the
+// ID it had first, so every hop deduplicates on the same key.
+const SourceMessageIDAttribute = "shovelSourceMessageId"
+
+const (
+	defaultDedupTTL     = time.Hour
+	defaultDedupMaxKeys = 100000
+	maxDedupMaxKeys     = 1000000
+)
+
+// DedupConfig makes a job acknowledge messages it already published instead
+// of publishing them again
+type DedupConfig struct {
+	Key        string `json:"key,omitempty"`        // DedupKeyMessageID (default), DedupKeyPayload or "attribute:NAME"
+	TTL        string `json:"ttl,omitempty"`        // How long keys of published messages are remembered, default 1h
+	MaxKeys    int    `json:"maxKeys,omitempty"`    // Most keys remembered, the oldest are forgotten first. Default 100000.
+	AcrossJobs bool   `json:"acrossJobs,omitempty"` // Share keys through the job store with other jobs into the same target
+}
+
+// DedupStore is implemented by job stores that can share dedup keys between
+// jobs
+type DedupStore interface {
+	// LoadDedupKeys returns the unexpired keys of scope with their expiry
+	LoadDedupKeys(ctx context.Context, scope string) (map[string]time.Time, error)
+	// SaveDedupKeys adds keys to scope, drops the expired ones and keeps at
+	// most max keys, those expiring last
+	SaveDedupKeys(ctx context.Context, scope string, keys map[string]time.Time, max int) error
+}
+
+// validateDedup validates the optional dedup settings of a request
+func validateDedup(req *ShovelRequest) error {
+	d := req.Dedup
+	if d == nil {
+		return nil
+	}
+	if req.SourceKafka != nil {
+		return fmt.Errorf("dedup cannot be used with sourceKafka")
+	}
+	switch {
+	case d.Key == "" || d.Key == DedupKeyMessageID || d.Key == DedupKeyPayload:
+	case strings.HasPrefix(d.Key, DedupKeyAttribute) && len(d.Key) > len(DedupKeyAttribute):
+	default:
+		return fmt.Errorf("dedup.key must be %q, %q or \"%sNAME\"", DedupKeyMessageID, DedupKeyPayload, DedupKeyAttribute)
+	}
+	if d.TTL != "" {
+		ttl, err := time.ParseDuration(d.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("dedup.ttl must be a positive duration like 30m")
+		}
+	}
+	if d.MaxKeys < 0 || d.MaxKeys > maxDedupMaxKeys {
+		return fmt.Errorf("dedup.maxKeys must be between 0 and %d", maxDedupMaxKeys)
+	}
+	if _, ok := jobStore.(DedupStore); d.AcrossJobs && !ok {
+		return fmt.Errorf("dedup.acrossJobs is not supported by the configured job store")
+	}
+	return nil
+}
+
+// ttl returns the configured TTL or the default
+func (d *DedupConfig) ttl() time.Duration {
+	if ttl, err := time.ParseDuration(d.TTL); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultDedupTTL
+}
+
+// maxKeys returns the configured cache bound or the default
+func (d *DedupConfig) maxKeys() int {
+	if d.MaxKeys > 0 {
+		return d.MaxKeys
+	}
+	return defaultDedupMaxKeys
+}
+
+// keyName returns the configured key with the default applied
+func (d *DedupConfig) keyName() string {
+	if d.Key == "" {
+		return DedupKeyMessageID
+	}
+	return d.Key
+}
+
+// dedupResult is the outcome of claiming a key
+type dedupResult int
+
+const (
+	dedupNew       dedupResult = iota // Not seen yet, the caller publishes the message
+	dedupDuplicate                    // Already published
+	dedupPending                      // A publish with this key is still running
+)
+
+// dedupCache remembers the keys of published messages for a TTL. It holds at
+// most max keys and forgets the oldest first. Keys of messages whose publish
+// is still running are kept apart until they are committed or released.
+type dedupCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*list.Element
+	order   *list.List // *dedupEntry, oldest first
+	pending map[string]bool
+	unsaved map[string]time.Time // Committed keys not shared through the job store yet
+	now     func() time.Time
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newDedupCache(ttl time.Duration, max int) *dedupCache {
+	return &dedupCache{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		pending: make(map[string]bool),
+		unsaved: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// claim reserves key for a message that is about to be published unless the
+// key is known already
+func (c *dedupCache) claim(key string) dedupResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.expireLocked(now)
+	if e, ok := c.entries[key]; ok {
+		if e.Value.(*dedupEntry).expires.After(now) {
+			return dedupDuplicate
+		}
+		c.removeLocked(e)
+	}
+	if c.pending[key] {
+		return dedupPending
+	}
+	c.pending[key] = true
+	return dedupNew
+}
+
+// commit remembers a claimed key after its message was published
+func (c *dedupCache) commit(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, key)
+	expires := c.now().Add(c.ttl)
+	c.addLocked(key, expires)
+	if _, ok := c.entries[key]; ok {
+		c.unsaved[key] = expires
+	}
+}
+
+// release forgets a claimed key whose message was not published
+func (c *dedupCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, key)
+}
+
+// load adds keys remembered elsewhere, e.g. by earlier jobs
+func (c *dedupCache) load(keys map[string]time.Time) {
+	entries := make([]dedupEntry, 0, len(keys))
+	for key, expires := range keys {
+		entries = append(entries, dedupEntry{key: key, expires: expires})
+	}
+	sort.Slice(entries, func(i, k int) bool { return entries[i].expires.Before(entries[k].expires) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, e := range entries {
+		if e.expires.After(now) {
+			c.addLocked(e.key, e.expires)
+		}
+	}
+}
+
+// snapshot returns the remembered keys with their expiry
+func (c *dedupCache) snapshot() map[string]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked(c.now())
+	keys := make(map[string]time.Time, len(c.entries))
+	for key, e := range c.entries {
+		keys[key] = e.Value.(*dedupEntry).expires
+	}
+	return keys
+}
+
+// unsavedKeys returns the keys committed since they were last saved
+func (c *dedupCache) unsavedKeys() map[string]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make(map[string]time.Time, len(c.unsaved))
+	for key, expires := range c.unsaved {
+		keys[key] = expires
+	}
+	return keys
+}
+
+// markSaved records that keys were saved, unless a key was committed again
+// since
+func (c *dedupCache) markSaved(keys map[string]time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, expires := range keys {
+		if c.unsaved[key].Equal(expires) {
+			delete(c.unsaved, key)
+		}
+	}
+}
+
+// reset forgets all keys, releasing their memory
+func (c *dedupCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.pending = make(map[string]bool)
+	c.unsaved = make(map[string]time.Time)
+}
+
+func (c *dedupCache) addLocked(key string, expires time.Time) {
+	if e, ok := c.entries[key]; ok {
+		c.removeLocked(e)
+	}
+	c.entries[key] = c.order.PushBack(&dedupEntry{key: key, expires: expires})
+	for c.order.Len() > c.max {
+		c.removeLocked(c.order.Front())
+	}
+}
+
+func (c *dedupCache) removeLocked(e *list.Element) {
+	c.order.Remove(e)
+	key := e.Value.(*dedupEntry).key
+	delete(c.entries, key)
+	delete(c.unsaved, key)
+}
+
+// expireLocked drops the expired keys at the front of the cache
+func (c *dedupCache) expireLocked(now time.Time) {
+	for e := c.order.Front(); e != nil && !e.Value.(*dedupEntry).expires.After(now); e = c.order.Front() {
+		c.removeLocked(e)
+	}
+}
+
+// dedupKey returns the dedup key of msg, or "" when the job does not
+// deduplicate or msg has no key
+func (j *Job) dedupKey(msg *pubsub.Message) string {
+	if j.dedup == nil {
+		return ""
+	}
+	switch key := j.Request.Dedup.keyName(); {
+	case key == DedupKeyPayload:
+		sum := sha256.Sum256(msg.Data)
+		return hex.EncodeToString(sum[:])
+	case strings.HasPrefix(key, DedupKeyAttribute):
+		return msg.Attributes[strings.TrimPrefix(key, DedupKeyAttribute)]
+	default:
+		return sourceMessageID(msg)
+	}
+}
+
+// sourceMessageID returns the ID of the message msg was originally moved
+// from, or its own ID
+func sourceMessageID(msg *pubsub.Message) string {
+	if id := msg.Attributes[SourceMessageIDAttribute]; id != "" {
+		return id
+	}
+	return msg.ID
+}
+
+// withSourceMessageID adds the source message ID of msg to attributes, which
+// may be shared with msg and is copied first
+func (j *Job) withSourceMessageID(attributes map[string]string, msg *pubsub.Message) map[string]string {
+	if j.dedup == nil || attributes[SourceMessageIDAttribute] != "" {
+		return attributes
+	}
+	out := make(map[string]string, len(attributes)+1)
+	for k, v := range attributes {
+		out[k] = v
+	}
+	out[SourceMessageIDAttribute] = sourceMessageID(msg)
+	return out
+}
+
+// recordDuplicate counts a message that was acknowledged without publishing
+// because it was published before
+func (j *Job) recordDuplicate() {
+	j.metrics.filtered.Inc()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.duplicates++
+	j.lastProgress = time.Now()
+}
+
+// dedupScope names the keys shared between jobs: those into the same target
+// with the same kind of key
+func dedupScope(req ShovelRequest) string {
+	return req.TargetTopic + " " + req.Dedup.keyName()
+}
+
+// loadDedupKeys adds the keys earlier jobs shared through the job store
+func (j *Job) loadDedupKeys(ctx context.Context) error {
+	if j.dedup == nil || !j.Request.Dedup.AcrossJobs {
+		return nil
+	}
+	store, ok := jobStore.(DedupStore)
+	if !ok {
+		return fmt.Errorf("job store does not support dedup keys")
+	}
+	keys, err := store.LoadDedupKeys(ctx, dedupScope(j.Request))
+	if err != nil {
+		return fmt.Errorf("failed to load dedup keys: %v", err)
+	}
+	j.dedup.load(keys)
+	return nil
+}
+
+// saveDedupKeys shares the keys the job added since the last save through
+// the job store, logging failures. Keys that failed are retried next time.
+func (j *Job) saveDedupKeys(ctx context.Context) {
+	if j.dedup == nil || !j.Request.Dedup.AcrossJobs {
+		return
+	}
+	store, ok := jobStore.(DedupStore)
+	if !ok {
+		return
+	}
+	keys := j.dedup.unsavedKeys()
+	if len(keys) == 0 {
+		return
+	}
+	if err := store.SaveDedupKeys(ctx, dedupScope(j.Request), keys, j.Request.Dedup.maxKeys()); err != nil {
+		j.log(ctx, slog.LevelError, "Failed to save dedup keys", "error", err)
+		return
+	}
+	j.dedup.markSaved(keys)
+}
+
+// LoadDedupKeys returns the unexpired keys of scope
+func (s *MemoryJobStore) LoadDedupKeys(_ context.Context, scope string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	keys := make(map[string]time.Time, len(s.dedupKeys[scope]))
+	for key, expires := range s.dedupKeys[scope] {
+		if expires.After(now) {
+			keys[key] = expires
+		}
+	}
+	return keys, nil
+}
+
+// SaveDedupKeys adds keys to scope, drops the expired ones and keeps at most
+// max keys
+func (s *MemoryJobStore) SaveDedupKeys(_ context.Context, scope string, keys map[string]time.Time, max int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dedupKeys == nil {
+		s.dedupKeys = make(map[string]map[string]time.Time)
+	}
+	s.dedupKeys[scope] = mergeDedupKeys(s.dedupKeys[scope], keys, max)
+	return nil
+}
+
+// dedupLine is one key in the dedup file of a FileJobStore
+type dedupLine struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// LoadDedupKeys reads the unexpired keys of scope
+func (s *FileJobStore) LoadDedupKeys(_ context.Context, scope string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, _, err := s.readDedupFile(scope)
+	if err != nil {
+		return nil, err
+	}
+	return mergeDedupKeys(keys, nil, 0), nil
+}
+
+// SaveDedupKeys appends keys to the file of scope. Once the file holds more
+// than twice max lines, it is rewritten without the expired and the oldest
+// keys.
+func (s *FileJobStore) SaveDedupKeys(_ context.Context, scope string, keys map[string]time.Time, max int) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.dedupPath(scope)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create dedup directory: %v", err)
+	}
+	if s.dedupLines == nil {
+		s.dedupLines = make(map[string]int)
+	}
+	lines, ok := s.dedupLines[scope]
+	if !ok {
+		_, n, err := s.readDedupFile(scope)
+		if err != nil {
+			return err
+		}
+		lines = n
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for key, expires := range keys {
+		if err := enc.Encode(dedupLine{Key: key, Expires: expires}); err != nil {
+			return fmt.Errorf("failed to encode dedup keys: %v", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to write dedup keys: %v", err)
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write dedup keys: %v", err)
+	}
+	s.dedupLines[scope] = lines + len(keys)
+
+	if s.dedupLines[scope] > 2*max {
+		return s.compactDedupFile(scope, max)
+	}
+	return nil
+}
+
+// compactDedupFile rewrites the file of scope with at most max unexpired keys
+func (s *FileJobStore) compactDedupFile(scope string, max int) error {
+	keys, _, err := s.readDedupFile(scope)
+	if err != nil {
+		return err
+	}
+	keys = mergeDedupKeys(keys, nil, max)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for key, expires := range keys {
+		if err := enc.Encode(dedupLine{Key: key, Expires: expires}); err != nil {
+			return fmt.Errorf("failed to encode dedup keys: %v", err)
+		}
+	}
+	path := s.dedupPath(scope)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o640); err != nil {
+		return fmt.Errorf("failed to compact dedup keys: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to compact dedup keys: %v", err)
+	}
+	s.dedupLines[scope] = len(keys)
+	return nil
+}
+
+// dedupPath returns the file of scope. Dedup files live in a subdirectory so
+// that List does not mistake them for job records.
+func (s *FileJobStore) dedupPath(scope string) string {
+	sum := sha256.Sum256([]byte(scope))
+	return filepath.Join(s.dir, "dedup", hex.EncodeToString(sum[:16])+".jsonl")
+}
+
+// readDedupFile returns the keys in the file of scope and its number of lines
+func (s *FileJobStore) readDedupFile(scope string) (map[string]time.Time, int, error) {
+	keys := make(map[string]time.Time)
+	f, err := os.Open(s.dedupPath(scope))
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read dedup keys: %v", err)
+	}
+	defer f.Close()
+
+	lines := 0
+	dec := json.NewDecoder(f)
+	for {
+		var line dedupLine
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			return keys, lines, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode dedup keys: %v", err)
+		}
+		lines++
+		if line.Expires.After(keys[line.Key]) {
+			keys[line.Key] = line.Expires
+		}
+	}
+}
+
+// mergeDedupKeys adds keys to existing, keeping the later expiry of keys in
+// both. Expired keys are dropped and, when max is positive, only the max keys
+// expiring last are kept. existing is updated in place and returned.
+func mergeDedupKeys(existing, keys map[string]time.Time, max int) map[string]time.Time {
+	if existing == nil {
+		existing = make(map[string]time.Time, len(keys))
+	}
+	now := time.Now()
+	for key, expires := range keys {
+		if expires.After(existing[key]) {
+			existing[key] = expires
+		}
+	}
+	for key, expires := range existing {
+		if !expires.After(now) {
+			delete(existing, key)
+		}
+	}
+	if max <= 0 || len(existing) <= max {
+		return existing
+	}
+
+	// Keys share the TTL of their scope, so those expiring first are the oldest
+	entries := make([]dedupEntry, 0, len(existing))
+	for key, expires := range existing {
+		entries = append(entries, dedupEntry{key: key, expires: expires})
+	}
+	sort.Slice(entries, func(i, k int) bool { return entries[i].expires.Before(entries[k].expires) })
+	for _, e := range entries[:len(entries)-max] {
+		delete(existing, e.key)
+	}
+	return existing
+}
@@ -0,0 +1,301 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestDedupCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	c := newDedupCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	if got := c.claim("a"); got != dedupNew {
+		t.Fatalf("Expected new key, got %v", got)
+	}
+	if got := c.claim("a"); got != dedupPending {
+		t.Errorf("Expected pending key while publishing, got %v", got)
+	}
+	c.commit("a")
+	if got := c.claim("a"); got != dedupDuplicate {
+		t.Errorf("Expected duplicate after commit, got %v", got)
+	}
+
+	// A failed publish releases the key for the redelivery
+	c.claim("b")
+	c.release("b")
+	if got := c.claim("b"); got != dedupNew {
+		t.Errorf("Expected released key to be new, got %v", got)
+	}
+	c.commit("b")
+
+	// The oldest key is forgotten once the cache is full
+	c.claim("c")
+	c.commit("c")
+	if got := c.claim("a"); got != dedupNew {
+		t.Errorf("Expected evicted key to be new, got %v", got)
+	}
+	c.release("a")
+
+	now = now.Add(2 * time.Minute)
+	if got := c.claim("c"); got != dedupNew {
+		t.Errorf("Expected expired key to be new, got %v", got)
+	}
+	if keys := c.snapshot(); len(keys) != 0 {
+		t.Errorf("Expected all keys to be expired, got %v", keys)
+	}
+
+	c.load(map[string]time.Time{"d": now.Add(time.Minute), "e": now.Add(-time.Minute)})
+	if keys := c.snapshot(); len(keys) != 1 || keys["d"].IsZero() {
+		t.Errorf("Expected only the unexpired loaded key, got %v", keys)
+	}
+}
+
+func TestDedupCache_UnsavedKeys(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	c := newDedupCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+	c.load(map[string]time.Time{"loaded": now.Add(time.Minute)})
+
+	c.claim("a")
+	c.commit("a")
+	keys := c.unsavedKeys()
+	if len(keys) != 1 || keys["a"].IsZero() {
+		t.Fatalf("Expected only the committed key to be unsaved, got %v", keys)
+	}
+
+	// Keys committed again while saving stay unsaved
+	now = now.Add(time.Second)
+	c.claim("b")
+	c.commit("b")
+	c.markSaved(keys)
+	if keys := c.unsavedKeys(); len(keys) != 1 || keys["b"].IsZero() {
+		t.Errorf("Expected only the key committed after the save, got %v", keys)
+	}
+
+	// Evicted keys are not saved
+	c.claim("c")
+	c.commit("c")
+	c.claim("d")
+	c.commit("d")
+	if keys := c.unsavedKeys(); len(keys) != 2 || !keys["b"].IsZero() {
+		t.Errorf("Expected only the keys still cached, got %v", keys)
+	}
+}
+
+// plainJobStore hides the dedup support of a store
+type plainJobStore struct {
+	JobStore
+}
+
+func TestValidateDedup(t *testing.T) {
+	original := jobStore
+	defer SetJobStore(original)
+
+	tests := []struct {
+		name        string
+		dedup       *DedupConfig
+		kafka       bool
+		store       JobStore
+		expectError bool
+	}{
+		{name: "defaults", dedup: &DedupConfig{}},
+		{name: "payload", dedup: &DedupConfig{Key: DedupKeyPayload, TTL: "30m", MaxKeys: 1000}},
+		{name: "attribute", dedup: &DedupConfig{Key: "attribute:eventId"}},
+		{name: "across jobs", dedup: &DedupConfig{AcrossJobs: true}},
+		{name: "unknown key", dedup: &DedupConfig{Key: "orderId"}, expectError: true},
+		{name: "attribute without name", dedup: &DedupConfig{Key: "attribute:"}, expectError: true},
+		{name: "invalid ttl", dedup: &DedupConfig{TTL: "soon"}, expectError: true},
+		{name: "negative ttl", dedup: &DedupConfig{TTL: "-1m"}, expectError: true},
+		{name: "too many keys", dedup: &DedupConfig{MaxKeys: maxDedupMaxKeys + 1}, expectError: true},
+		{name: "kafka source", dedup: &DedupConfig{}, kafka: true, expectError: true},
+		{name: "store without dedup keys", dedup: &DedupConfig{AcrossJobs: true}, store: plainJobStore{NewMemoryJobStore()}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			if store == nil {
+				store = NewMemoryJobStore()
+			}
+			SetJobStore(store)
+			req := ShovelRequest{Dedup: tt.dedup}
+			if tt.kafka {
+				req.SourceKafka = &KafkaSource{Topic: "orders"}
+			}
+			err := validateDedup(&req)
+			if (err != nil) != tt.expectError {
+				t.Errorf("Expected error %v, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestJob_DedupKey(t *testing.T) {
+	msg := &pubsub.Message{ID: "42", Data: []byte("payload"), Attributes: map[string]string{"eventId": "e-1"}}
+	moved := &pubsub.Message{ID: "43", Attributes: map[string]string{SourceMessageIDAttribute: "7"}}
+
+	tests := []struct {
+		name     string
+		dedup    *DedupConfig
+		msg      *pubsub.Message
+		expected string
+	}{
+		{name: "disabled", msg: msg},
+		{name: "message ID", dedup: &DedupConfig{}, msg: msg, expected: "42"},
+		{name: "passed through message ID", dedup: &DedupConfig{}, msg: moved, expected: "7"},
+		{name: "payload", dedup: &DedupConfig{Key: DedupKeyPayload}, msg: msg, expected: "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5"},
+		{name: "attribute", dedup: &DedupConfig{Key: "attribute:eventId"}, msg: msg, expected: "e-1"},
+		{name: "missing attribute", dedup: &DedupConfig{Key: "attribute:orderId"}, msg: msg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newJob("shovel-dedup-test", ShovelRequest{Dedup: tt.dedup})
+			if got := job.dedupKey(tt.msg); got != tt.expected {
+				t.Errorf("Expected key %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	job := newJob("shovel-dedup-test", ShovelRequest{Dedup: &DedupConfig{}})
+	if got := job.withSourceMessageID(msg.Attributes, msg); got[SourceMessageIDAttribute] != "42" || msg.Attributes[SourceMessageIDAttribute] != "" {
+		t.Errorf("Expected a copy of the attributes with the source ID, got %v and %v", got, msg.Attributes)
+	}
+	if got := job.withSourceMessageID(moved.Attributes, moved); got[SourceMessageIDAttribute] != "7" {
+		t.Errorf("Expected the first source ID to be kept, got %v", got)
+	}
+}
+
+func TestDedupStore(t *testing.T) {
+	fileStore, err := NewFileJobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	stores := map[string]DedupStore{"memory": NewMemoryJobStore(), "file": fileStore}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			later := time.Now().Add(time.Hour)
+			if err := store.SaveDedupKeys(ctx, "scope", map[string]time.Time{"a": later, "b": time.Now().Add(-time.Minute)}, 10); err != nil {
+				t.Fatalf("Failed to save keys: %v", err)
+			}
+			if err := store.SaveDedupKeys(ctx, "scope", map[string]time.Time{"c": later}, 10); err != nil {
+				t.Fatalf("Failed to save keys: %v", err)
+			}
+			keys, err := store.LoadDedupKeys(ctx, "scope")
+			if err != nil {
+				t.Fatalf("Failed to load keys: %v", err)
+			}
+			if len(keys) != 2 || !keys["a"].Equal(later) || !keys["c"].Equal(later) {
+				t.Errorf("Expected the unexpired keys of both saves, got %v", keys)
+			}
+			if other, _ := store.LoadDedupKeys(ctx, "other"); len(other) != 0 {
+				t.Errorf("Expected scopes to be separate, got %v", other)
+			}
+
+			// The newest keys are kept, however many saves there are. The file
+			// store holds up to twice as many until it compacts.
+			for i := 0; i < 10; i++ {
+				batch := map[string]time.Time{}
+				for k := 0; k < 3; k++ {
+					batch[fmt.Sprintf("capped-%d-%d", i, k)] = later.Add(time.Duration(i) * time.Minute)
+				}
+				if err := store.SaveDedupKeys(ctx, "capped", batch, 5); err != nil {
+					t.Fatalf("Failed to save keys: %v", err)
+				}
+			}
+			keys, err = store.LoadDedupKeys(ctx, "capped")
+			if err != nil {
+				t.Fatalf("Failed to load keys: %v", err)
+			}
+			if len(keys) > 2*5 {
+				t.Errorf("Expected the keys to be capped, got %d", len(keys))
+			}
+			for k := 0; k < 3; k++ {
+				if _, ok := keys[fmt.Sprintf("capped-9-%d", k)]; !ok {
+					t.Errorf("Expected the newest keys to be kept, got %v", keys)
+				}
+			}
+		})
+	}
+
+	// Dedup files are not job records
+	records, err := fileStore.List(context.Background(), JobFilter{})
+	if err != nil || len(records) != 0 {
+		t.Errorf("Expected no job records, got %v, %v", records, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(fileStore.dir, "dedup", "*.jsonl")); len(files) != 2 {
+		t.Errorf("Expected a dedup file per scope, got %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(fileStore.dir, "dedup")); err != nil {
+		t.Errorf("Expected dedup directory: %v", err)
+	}
+}
+
+func TestIntegration_Dedup(t *testing.T) {
+	useTimeouts(t, time.Minute, 3*time.Second)
+	original := jobStore
+	SetJobStore(NewMemoryJobStore())
+	t.Cleanup(func() { SetJobStore(original) })
+
+	e := newIntegrationEnv(t)
+	var msgs []*pubsub.Message
+	for i := 0; i < 20; i++ {
+		// Every event is published twice, as an at-least-once producer would
+		msgs = append(msgs, &pubsub.Message{Data: []byte(fmt.Sprintf("event-%d", i%10)), Attributes: map[string]string{"eventId": fmt.Sprint(i % 10)}})
+	}
+	e.publish(msgs...)
+
+	dedup := &DedupConfig{Key: "attribute:eventId", AcrossJobs: true}
+	status := e.run(ShovelRequest{AllMessages: true, Dedup: dedup})
+	if status.ProcessedCount != 10 || status.DuplicateCount != 10 {
+		t.Errorf("Expected 10 processed and 10 duplicates, got %d and %d", status.ProcessedCount, status.DuplicateCount)
+	}
+	published := e.published(10)
+	if len(published) != 10 {
+		t.Errorf("Expected 10 published messages, got %d", len(published))
+	}
+	for _, msg := range published {
+		if msg.Attributes[SourceMessageIDAttribute] == "" {
+			t.Errorf("Expected the source message ID to be passed through, got %v", msg.Attributes)
+		}
+	}
+	if remaining := e.remaining(0); len(remaining) != 0 {
+		t.Errorf("Expected duplicates to be acknowledged, got %d messages left", len(remaining))
+	}
+
+	// A later job into the same target skips what the first one published
+	e.publish(msgs[:5]...)
+	status = e.run(ShovelRequest{AllMessages: true, Dedup: dedup})
+	if status.ProcessedCount != 0 || status.DuplicateCount != 5 {
+		t.Errorf("Expected 5 duplicates across jobs, got %d processed and %d duplicates", status.ProcessedCount, status.DuplicateCount)
+	}
+}
+
+func TestFaults_DuplicateDeliveriesWithDedup(t *testing.T) {
+	useTimeouts(t, time.Minute, 5*time.Second)
+	faults := &faultInjector{duplicateRate: 0.5}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	e.publishN(50)
+
+	status := e.run(ShovelRequest{AllMessages: true, Dedup: &DedupConfig{}})
+	if _, _, duplicates := faults.counts(); duplicates == 0 {
+		t.Fatal("Expected duplicate deliveries to be injected")
+	}
+	// Duplicates arriving while the original is publishing are nacked, later
+	// ones are acknowledged and counted
+	if status.ProcessedCount != 50 {
+		t.Errorf("Expected every message to be published once, got %d", status.ProcessedCount)
+	}
+	if moved := e.checkNothingLost(); moved != 50 {
+		t.Errorf("Expected all 50 messages to be moved, got %d", moved)
+	}
+}
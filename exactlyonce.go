@@ -0,0 +1,132 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// ackStatusUnconfirmed counts acks whose result was not known before the job
+// stopped waiting for it
+const ackStatusUnconfirmed = "Unconfirmed"
+
+// ackError reports an acknowledgement that Pub/Sub did not confirm
+type ackError struct {
+	status string
+	err    error
+}
+
+func (e *ackError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("ack failed: %s", e.status)
+	}
+	return fmt.Sprintf("ack failed: %s: %v", e.status, e.err)
+}
+
+func (e *ackError) Unwrap() error {
+	return e.err
+}
+
+// ackStatusName returns the name of an acknowledgement status
+func ackStatusName(status pubsub.AcknowledgeStatus) string {
+	switch status {
+	case pubsub.AcknowledgeStatusSuccess:
+		return "Success"
+	case pubsub.AcknowledgeStatusPermissionDenied:
+		return "PermissionDenied"
+	case pubsub.AcknowledgeStatusFailedPrecondition:
+		return "FailedPrecondition"
+	case pubsub.AcknowledgeStatusInvalidAckID:
+		return "InvalidAckID"
+	default:
+		return "Other"
+	}
+}
+
+// detectExactlyOnce records whether the source subscription has exactly-once
+// delivery enabled. Without permission to read the subscription, acks are
+// sent without waiting for their result.
+func (j *Job) detectExactlyOnce(ctx context.Context, sub *pubsub.Subscription) {
+	config, err := sub.Config(ctx)
+	if err != nil {
+		j.log(ctx, slog.LevelWarn, "Failed to read source subscription, acks are not confirmed", "error", err)
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.exactlyOnce = config.EnableExactlyOnceDelivery
+}
+
+// isExactlyOnce reports whether acks of the job are confirmed
+func (j *Job) isExactlyOnce() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.exactlyOnce
+}
+
+// ackMessage returns a stage that acknowledges msg. On exactly-once
+// subscriptions it waits until Pub/Sub confirmed the ack and fails with an
+// *ackError otherwise.
+func (j *Job) ackMessage(msg *pubsub.Message) func() error {
+	return func() error {
+		if !j.isExactlyOnce() {
+			msg.Ack()
+			return nil
+		}
+		return waitForAck(j.publishContext(), msg.AckWithResult())
+	}
+}
+
+// nackMessage returns a stage that hands msg back to the subscription. A nack
+// that fails is only logged: the message is redelivered once its ack deadline
+// expires anyway.
+func (j *Job) nackMessage(ctx context.Context, msg *pubsub.Message) func() error {
+	return func() error {
+		if !j.isExactlyOnce() {
+			msg.Nack()
+			return nil
+		}
+		result := msg.NackWithResult()
+		go func() {
+			if err := waitForAck(j.publishContext(), result); err != nil {
+				j.logMessage(ctx, "Failed to nack message", msg.ID, "error", err)
+			}
+		}()
+		return nil
+	}
+}
+
+// waitForAck waits for the result of an ack or nack
+func waitForAck(ctx context.Context, result *pubsub.AckResult) error {
+	status, err := result.Get(ctx)
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return &ackError{status: ackStatusUnconfirmed, err: err}
+	}
+	if status != pubsub.AcknowledgeStatusSuccess {
+		return &ackError{status: ackStatusName(status), err: err}
+	}
+	return nil
+}
+
+// recordAckFailed counts a message that was published but whose ack failed.
+// The source redelivers it, so it may reach the target twice.
+func (j *Job) recordAckFailed(err error) {
+	j.metrics.failed.Inc()
+	status := ackStatusUnconfirmed
+	var ackErr *ackError
+	if errors.As(err, &ackErr) {
+		status = ackErr.status
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.ackFailed++
+	j.lastProgress = time.Now()
+	if j.ackErrorCounts == nil {
+		j.ackErrorCounts = make(map[string]int)
+	}
+	j.ackErrorCounts[status]++
+}
@@ -0,0 +1,94 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+)
+
+func TestAckError(t *testing.T) {
+	err := &ackError{status: ackStatusName(pubsub.AcknowledgeStatusInvalidAckID), err: errors.New("expired")}
+	if err.Error() != "ack failed: InvalidAckID: expired" {
+		t.Errorf("Expected the status and cause in the message, got %q", err.Error())
+	}
+
+	job := newJob("shovel-ack-test", ShovelRequest{})
+	job.tryAccept(0)
+	job.tryAccept(0)
+	job.recordAckFailed(err)
+	job.recordAckFailed(context.Canceled)
+	status := job.Status()
+	if status.AckFailedCount != 2 || status.InFlight != 0 {
+		t.Errorf("Expected 2 failed acks and nothing in flight, got %d and %d", status.AckFailedCount, status.InFlight)
+	}
+	if status.AckErrorCounts["InvalidAckID"] != 1 || status.AckErrorCounts[ackStatusUnconfirmed] != 1 {
+		t.Errorf("Expected failed acks counted by status, got %v", status.AckErrorCounts)
+	}
+}
+
+// enableExactlyOnce turns on exactly-once delivery for the source subscription
+func (e *integrationEnv) enableExactlyOnce() {
+	e.t.Helper()
+	_, err := e.sourceSub.Update(context.Background(), pubsub.SubscriptionConfigToUpdate{EnableExactlyOnceDelivery: true})
+	if err != nil {
+		e.t.Skipf("Exactly-once delivery not supported: %v", err)
+	}
+}
+
+func TestIntegration_ExactlyOnce(t *testing.T) {
+	skipInShortMode(t)
+	tests := []struct {
+		name        string
+		exactlyOnce bool
+	}{
+		{name: "at least once"},
+		{name: "exactly once", exactlyOnce: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTimeouts(t, time.Minute, 3*time.Second)
+			e := newIntegrationEnv(t)
+			if tt.exactlyOnce {
+				e.enableExactlyOnce()
+			}
+			e.publishN(20)
+
+			status := e.run(ShovelRequest{AllMessages: true})
+			if status.ExactlyOnce != tt.exactlyOnce {
+				t.Errorf("Expected exactlyOnce %v, got %v", tt.exactlyOnce, status.ExactlyOnce)
+			}
+			if status.ProcessedCount != 20 || status.AckFailedCount != 0 {
+				t.Errorf("Expected 20 processed and no failed acks, got %d and %d", status.ProcessedCount, status.AckFailedCount)
+			}
+			if moved := e.checkNothingLost(); moved != 20 {
+				t.Errorf("Expected all 20 messages to be moved, got %d", moved)
+			}
+		})
+	}
+}
+
+func TestFaults_ExactlyOnceAckFailures(t *testing.T) {
+	useTimeouts(t, 5*time.Second, time.Minute)
+	faults := &faultInjector{ackCode: codes.PermissionDenied}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	e.enableExactlyOnce()
+	e.publishN(10)
+
+	// Published messages whose ack fails are not processed, the source
+	// delivers them again
+	status := e.run(ShovelRequest{NumMessages: 10})
+	if status.ProcessedCount != 0 || status.AckFailedCount != 10 {
+		t.Errorf("Expected 10 failed acks and none processed, got %d and %d", status.AckFailedCount, status.ProcessedCount)
+	}
+	if status.AckErrorCounts["PermissionDenied"] != 10 {
+		t.Errorf("Expected failed acks counted as PermissionDenied, got %v", status.AckErrorCounts)
+	}
+	if moved := e.checkNothingLost(); moved != 10 {
+		t.Errorf("Expected all 10 messages to be published, got %d", moved)
+	}
+}
@@ -0,0 +1,290 @@
+package shovel
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// faultInjector injects failures into the RPCs of the shovel's Pub/Sub
+// clients. The zero value injects nothing.
+type faultInjector struct {
+	publishErrorRate float64       // Fraction of Publish calls that fail
+	publishCode      codes.Code    // Code of injected publish errors
+	ackDelay         time.Duration // Delay of every Acknowledge call
+	ackCode          codes.Code    // Fail every Acknowledge call with this code
+	disconnectEvery  int           // Break the streaming pull before every nth response
+	duplicateRate    float64       // Fraction of streaming pull responses delivered twice
+	receiveCode      codes.Code    // Fail opening streaming pulls with this code
+
+	mu              sync.Mutex
+	rng             *rand.Rand
+	responses       int
+	publishFailures int
+	disconnects     int
+	duplicates      int
+}
+
+// dialOptions returns the interceptors that inject the faults
+func (f *faultInjector) dialOptions() []grpc.DialOption {
+	f.rng = rand.New(rand.NewSource(1))
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(f.intercept),
+		grpc.WithStreamInterceptor(f.interceptStream),
+	}
+}
+
+func (f *faultInjector) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	switch {
+	case strings.HasSuffix(method, "/Publish"):
+		if f.roll(f.publishErrorRate, &f.publishFailures) {
+			return status.Error(f.publishCode, "injected publish failure")
+		}
+	case strings.HasSuffix(method, "/Acknowledge"):
+		if f.ackCode != codes.OK {
+			return status.Error(f.ackCode, "injected ack failure")
+		}
+		if f.ackDelay > 0 {
+			select {
+			case <-time.After(f.ackDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (f *faultInjector) interceptStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if !strings.HasSuffix(method, "/StreamingPull") {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	if f.receiveCode != codes.OK {
+		return nil, status.Error(f.receiveCode, "injected receive failure")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &faultyStream{ClientStream: stream, f: f, cancel: cancel}, nil
+}
+
+// roll reports with probability rate that a fault is due and counts it
+func (f *faultInjector) roll(rate float64, counter *int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rate > 0 && f.rng.Float64() < rate {
+		*counter++
+		return true
+	}
+	return false
+}
+
+// disconnect reports whether the stream breaks before the next response
+func (f *faultInjector) disconnect() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses++
+	if f.disconnectEvery > 0 && f.responses%f.disconnectEvery == 0 {
+		f.disconnects++
+		return true
+	}
+	return false
+}
+
+// counts returns how many faults were injected
+func (f *faultInjector) counts() (publishFailures, disconnects, duplicates int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.publishFailures, f.disconnects, f.duplicates
+}
+
+// faultyStream is a streaming pull that breaks down or repeats responses
+type faultyStream struct {
+	grpc.ClientStream
+	f       *faultInjector
+	cancel  context.CancelFunc
+	pending proto.Message // Response to deliver again
+}
+
+func (s *faultyStream) RecvMsg(m interface{}) error {
+	msg := m.(proto.Message)
+	if s.pending != nil {
+		proto.Reset(msg)
+		proto.Merge(msg, s.pending)
+		s.pending = nil
+		return nil
+	}
+	if s.f.disconnect() {
+		// The server keeps unread messages outstanding until their ack deadline
+		s.cancel()
+		return status.Error(codes.Unavailable, "injected disconnect")
+	}
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.f.roll(s.f.duplicateRate, &s.f.duplicates) {
+		s.pending = proto.Clone(msg)
+	}
+	return nil
+}
+
+// checkNothingLost verifies that no source message was acknowledged without
+// being published to the target. The fake server counts acks; against the
+// emulator, every message that was not published must still be on the source
+// subscription. It returns the number of distinct messages moved.
+func (e *integrationEnv) checkNothingLost() int {
+	e.t.Helper()
+	// The job waited for its publishes, the target holds all it will get
+	moved := map[string]bool{}
+	for _, msg := range e.published(0) {
+		moved[string(msg.Data)] = true
+	}
+
+	acked := map[string]bool{}
+	if e.srv != nil {
+		for id, data := range e.sourceIDs {
+			acked[data] = e.srv.Message(id).Acks > 0
+		}
+	} else {
+		for _, data := range e.sourceIDs {
+			acked[data] = true
+		}
+		for _, msg := range e.remaining(len(e.sourceIDs) - len(moved)) {
+			acked[string(msg.Data)] = false
+		}
+	}
+	for data, acked := range acked {
+		if acked && !moved[data] {
+			e.t.Errorf("Message %s was acknowledged without being published", data)
+		}
+	}
+	return len(moved)
+}
+
+func TestFaults_PublishErrors(t *testing.T) {
+	skipInShortMode(t)
+	tests := []struct {
+		name         string
+		code         codes.Code
+		expectFailed bool
+	}{
+		{name: "retried by the publisher", code: codes.Unavailable},
+		{name: "nacked and redelivered", code: codes.PermissionDenied, expectFailed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTimeouts(t, time.Minute, 5*time.Second)
+			faults := &faultInjector{publishErrorRate: 0.3, publishCode: tt.code}
+			e := newIntegrationEnv(t, faults.dialOptions()...)
+			e.publishN(50)
+
+			status := e.run(ShovelRequest{AllMessages: true})
+			if failures, _, _ := faults.counts(); failures == 0 {
+				t.Fatal("Expected publish failures to be injected")
+			}
+			if tt.expectFailed && (status.FailedCount == 0 || status.ErrorCounts[tt.code.String()] != status.FailedCount) {
+				t.Errorf("Expected failures counted as %s, got %d failed with %v", tt.code, status.FailedCount, status.ErrorCounts)
+			}
+			if !tt.expectFailed && status.FailedCount != 0 {
+				t.Errorf("Expected retries to hide the failures, got %d failed", status.FailedCount)
+			}
+			if moved := e.checkNothingLost(); moved != 50 {
+				t.Errorf("Expected all 50 messages to be moved eventually, got %d", moved)
+			}
+		})
+	}
+}
+
+func TestFaults_SlowAcks(t *testing.T) {
+	useTimeouts(t, time.Minute, 5*time.Second)
+	faults := &faultInjector{ackDelay: 500 * time.Millisecond}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	e.publishN(50)
+
+	status := e.run(ShovelRequest{AllMessages: true})
+	if status.ProcessedCount < 50 {
+		t.Errorf("Expected at least 50 processed messages, got %d", status.ProcessedCount)
+	}
+	if moved := e.checkNothingLost(); moved != 50 {
+		t.Errorf("Expected all 50 messages to be moved, got %d", moved)
+	}
+}
+
+func TestFaults_Disconnects(t *testing.T) {
+	// Unread messages of a broken stream come back after their ack deadline
+	useTimeouts(t, time.Minute, 15*time.Second)
+	faults := &faultInjector{disconnectEvery: 3}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	e.publishN(50)
+
+	status := e.run(ShovelRequest{AllMessages: true})
+	if _, disconnects, _ := faults.counts(); disconnects == 0 {
+		t.Fatal("Expected the stream to be disconnected")
+	}
+	if status.State != JobStateCompleted {
+		t.Errorf("Expected the job to survive disconnects, got %s: %s", status.State, status.Error)
+	}
+	if moved := e.checkNothingLost(); moved == 0 {
+		t.Error("Expected messages to be moved despite disconnects")
+	}
+}
+
+func TestFaults_DuplicateDeliveries(t *testing.T) {
+	useTimeouts(t, time.Minute, 5*time.Second)
+	faults := &faultInjector{duplicateRate: 0.5}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	e.publishN(50)
+
+	status := e.run(ShovelRequest{AllMessages: true})
+	if _, _, duplicates := faults.counts(); duplicates == 0 {
+		t.Fatal("Expected duplicate deliveries to be injected")
+	}
+	if status.FailedCount != 0 {
+		t.Errorf("Expected duplicates to be moved like any other message, got %d failed", status.FailedCount)
+	}
+	if moved := e.checkNothingLost(); moved != 50 {
+		t.Errorf("Expected all 50 messages to be moved, got %d", moved)
+	}
+}
+
+func TestFaults_ReceiveError(t *testing.T) {
+	faults := &faultInjector{receiveCode: codes.PermissionDenied}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	e.publishN(5)
+
+	status := e.run(ShovelRequest{NumMessages: 5})
+	if status.State != JobStateFailed || !strings.Contains(status.Error, "receive failed") {
+		t.Errorf("Expected the job to fail with the receive error, got %s: %q", status.State, status.Error)
+	}
+	if moved := e.checkNothingLost(); moved != 0 {
+		t.Errorf("Expected nothing to be moved, got %d", moved)
+	}
+}
+
+func TestFaults_Combined(t *testing.T) {
+	useTimeouts(t, time.Minute, 15*time.Second)
+	faults := &faultInjector{
+		publishErrorRate: 0.2,
+		publishCode:      codes.Internal,
+		ackDelay:         200 * time.Millisecond,
+		disconnectEvery:  5,
+		duplicateRate:    0.2,
+	}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	e.publishN(100)
+
+	e.run(ShovelRequest{AllMessages: true})
+	e.checkNothingLost()
+}
@@ -3,48 +3,70 @@ package shovel
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/api/option"
 )
 
 func init() {
 	functions.HTTP("Handler", Handler)
+	functions.HTTP("Status", StatusHandler)
+	functions.HTTP("Cancel", CancelHandler)
+	functions.HTTP("Health", HealthHandler)
+	functions.HTTP("List", ListHandler)
+	functions.HTTP("Metrics", MetricsHandler)
+	functions.HTTP("Events", StreamHandler)
 }
 
 // ShovelRequest represents the HTTP request payload
 type ShovelRequest struct {
-	NumMessages        int    `json:"numMessages,omitempty"` // Maximum number of messages to process
-	AllMessages        bool   `json:"allMessages,omitempty"` // Process all available messages
-	SourceSubscription string `json:"sourceSubscription"`    // Source subscription FQDN
-	TargetTopic        string `json:"targetTopic"`           // Target topic FQDN
+	NumMessages          int          `json:"numMessages,omitempty"`          // Maximum number of messages to process
+	AllMessages          bool         `json:"allMessages,omitempty"`          // Process all available messages
+	Mode                 string       `json:"mode,omitempty"`                 // "oneshot" (default) or "continuous"
+	SourceSubscription   string       `json:"sourceSubscription"`             // Source subscription FQDN
+	SourceKafka          *KafkaSource `json:"sourceKafka,omitempty"`          // Kafka source, alternative to sourceSubscription
+	TargetTopic          string       `json:"targetTopic"`                    // Target topic FQDN
+	SourceServiceAccount string       `json:"sourceServiceAccount,omitempty"` // Service account to impersonate for receiving
+	TargetServiceAccount string       `json:"targetServiceAccount,omitempty"` // Service account to impersonate for publishing
+	PropagateTrace       bool         `json:"propagateTrace,omitempty"`       // Carry W3C trace context in message attributes
+	CallbackURL          string       `json:"callbackUrl,omitempty"`          // URL that receives the job summary when the job ends
+	NotifyTopic          string       `json:"notifyTopic,omitempty"`          // Topic FQDN that receives the job summary when the job ends
+	Dedup                *DedupConfig `json:"dedup,omitempty"`                // Skip messages that were published before
+	SeekToTime           string       `json:"seekToTime,omitempty"`           // RFC 3339 time to seek the source subscription to before receiving
+	SeekToSnapshot       string       `json:"seekToSnapshot,omitempty"`       // Snapshot to seek the source subscription to before receiving
+	SnapshotBeforeSeek   bool         `json:"snapshotBeforeSeek,omitempty"`   // Snapshot the source subscription first so that the seek can be undone
 }
 
 // ShovelResponse represents the HTTP response
 type ShovelResponse struct {
-	Status         string `json:"status"`
-	Message        string `json:"message"`
-	ProcessedCount int    `json:"processedCount,omitempty"`
-	RequestID      string `json:"requestId,omitempty"`
+	Status         string             `json:"status"`
+	Message        string             `json:"message"`
+	ProcessedCount int                `json:"processedCount,omitempty"`
+	RequestID      string             `json:"requestId,omitempty"`
+	Findings       []PreflightFinding `json:"findings,omitempty"` // Why the preflight checks rejected the request
 }
 
 // Handler handles the shovel HTTP requests
 func Handler(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	w.Header().Set("Content-Type", "application/json")
 
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusNoContent)
+	// Set CORS headers and handle preflight requests
+	if handleCORS(w, r, "POST") {
+		return
+	}
+
+	r, ok := authenticate(w, r)
+	if !ok {
 		return
 	}
 
@@ -62,47 +84,106 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate request
-	if err := validateRequest(&req); err != nil {
-		respondWithError(w, err.Error(), http.StatusBadRequest)
+	if err := validateRequest(r.Context(), &req); err != nil {
+		code := http.StatusBadRequest
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			code = http.StatusForbidden
+			logger.Warn("Rejected request by policy", "principal", PrincipalFromContext(r.Context()).String(), "error", err)
+			auditRejected(r.Context(), req, policyErr)
+		}
+		respondWithError(w, err.Error(), code)
+		return
+	}
+
+	// Check resources and permissions before accepting the job
+	if err := preflight(r.Context(), req); err != nil {
+		var preflightErr *PreflightError
+		if !errors.As(err, &preflightErr) {
+			logger.Error("Failed to run preflight checks", "error", err)
+			respondWithError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		logger.Warn("Rejected request by preflight checks", "principal", PrincipalFromContext(r.Context()).String(), "error", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		response := ShovelResponse{Status: "error", Message: err.Error(), Findings: preflightErr.Findings}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Error("Failed to encode error response", "error", err)
+		}
 		return
 	}
 
 	// Generate request ID for tracking
-	requestID := fmt.Sprintf("shovel-%d", time.Now().UnixNano()/1000000)
-	log.Printf("Processing shovel request %s: %+v", requestID, req)
+	requestID := newRequestID()
 
 	// Start async processing
-	go func() {
-		ctx := context.Background()
-		processedCount, err := processShovelRequest(ctx, &req)
-		if err != nil {
-			log.Printf("Request %s failed: %v", requestID, err)
-		} else {
-			log.Printf("Request %s completed successfully, processed %d messages", requestID, processedCount)
-		}
-	}()
+	ctx := requestTraceContext(r.Context(), propagation.HeaderCarrier(r.Header))
+	job := startJob(ctx, requestID, req)
+	job.log(ctx, slog.LevelInfo, "Processing shovel request", slog.Any("request", req))
 
 	// Return immediate response
 	response := ShovelResponse{
 		Status:    "accepted",
 		Message:   "Message shoveling started asynchronously",
-		RequestID: requestID,
+		RequestID: job.ID,
 	}
 
 	w.WriteHeader(http.StatusAccepted)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Failed to encode response: %v", err)
+		logger.Error("Failed to encode response", "error", err)
 	}
 }
 
-// validateRequest validates the incoming request
-func validateRequest(req *ShovelRequest) error {
-	if req.SourceSubscription == "" {
-		return fmt.Errorf("sourceSubscription is required")
+// validateRequest validates the incoming request and checks it against the
+// policy for the caller of ctx
+func validateRequest(ctx context.Context, req *ShovelRequest) error {
+	if req.SourceSubscription == "" && req.SourceKafka == nil {
+		return fmt.Errorf("sourceSubscription or sourceKafka is required")
+	}
+	if req.SourceSubscription != "" && req.SourceKafka != nil {
+		return fmt.Errorf("cannot specify both sourceSubscription and sourceKafka")
+	}
+	if req.SourceKafka != nil {
+		if err := validateKafkaSource(req.SourceKafka); err != nil {
+			return err
+		}
 	}
 	if req.TargetTopic == "" {
 		return fmt.Errorf("targetTopic is required")
 	}
+	if req.SourceSubscription != "" {
+		if err := normalizeResourceName("sourceSubscription", &req.SourceSubscription, ParseSubscription); err != nil {
+			return err
+		}
+	}
+	if err := normalizeResourceName("targetTopic", &req.TargetTopic, ParseTopic); err != nil {
+		return err
+	}
+	if err := validateServiceAccounts(req); err != nil {
+		return err
+	}
+	if err := validateDedup(req); err != nil {
+		return err
+	}
+	if err := validateSeek(req); err != nil {
+		return err
+	}
+	if err := validateNotification(req); err != nil {
+		return err
+	}
+	if err := checkPolicy(ctx, req); err != nil {
+		return err
+	}
+	switch req.Mode {
+	case "", ModeOneShot:
+	case ModeContinuous:
+		if req.AllMessages || req.NumMessages != 0 {
+			return fmt.Errorf("numMessages and allMessages cannot be used with mode=continuous")
+		}
+		return nil
+	default:
+		return fmt.Errorf("mode must be %q or %q", ModeOneShot, ModeContinuous)
+	}
 	if !req.AllMessages && req.NumMessages <= 0 {
 		return fmt.Errorf("numMessages must be greater than 0 when allMessages is false")
 	}
@@ -112,26 +193,50 @@ func validateRequest(req *ShovelRequest) error {
 	return nil
 }
 
-// processShovelRequest handles the actual message shoveling
-func processShovelRequest(ctx context.Context, req *ShovelRequest) (int, error) {
-	// Create PubSub client
-	client, err := pubsub.NewClient(ctx, extractProjectID(req.SourceSubscription))
+// normalizeResourceName replaces *name with the full resource name parse
+// returns for it
+func normalizeResourceName(field string, name *string, parse func(string) (ResourceName, error)) error {
+	n, err := parse(*name)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create pubsub client: %v", err)
+		return fmt.Errorf("invalid %s: %v", field, err)
 	}
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Printf("Failed to close pubsub client: %v", err)
-		}
-	}()
+	*name = n.String()
+	return nil
+}
 
-	// Get source subscription
-	sourceSubName := extractResourceName(req.SourceSubscription)
-	sourceSub := client.Subscription(sourceSubName)
+// Processing timeouts of one-shot jobs
+var (
+	oneShotTimeout     = 5 * time.Minute
+	allMessagesTimeout = 10 * time.Minute // Longer timeout for "all messages"
+)
+
+// processShovelRequest handles the actual message shoveling
+func processShovelRequest(ctx context.Context, job *Job) (int, error) {
+	req := &job.Request
+	if req.SourceKafka != nil {
+		return processKafkaRequest(ctx, job)
+	}
+
+	source, err := ParseSubscription(req.SourceSubscription)
+	if err != nil {
+		return 0, err
+	}
+	target, err := ParseTopic(req.TargetTopic)
+	if err != nil {
+		return 0, err
+	}
 
-	// Get target topic
-	targetTopicName := extractResourceName(req.TargetTopic)
-	targetTopic := client.Topic(targetTopicName)
+	// Use the clients of the source and target projects and identities, which may differ
+	sourceClient, err := pubsubClient(ctx, source.Project, req.SourceServiceAccount)
+	if err != nil {
+		return 0, err
+	}
+	targetClient, err := pubsubClient(ctx, target.Project, req.TargetServiceAccount)
+	if err != nil {
+		return 0, err
+	}
+	sourceSub := sourceClient.SubscriptionInProject(source.ID, source.Project)
+	targetTopic := targetClient.TopicInProject(target.ID, target.Project)
 
 	// Check if target topic exists
 	exists, err := targetTopic.Exists(ctx)
@@ -142,12 +247,35 @@ func processShovelRequest(ctx context.Context, req *ShovelRequest) (int, error)
 		return 0, fmt.Errorf("target topic %s does not exist", req.TargetTopic)
 	}
 
+	job.detectExactlyOnce(ctx, sourceSub)
+	if err := job.loadDedupKeys(ctx); err != nil {
+		return 0, err
+	}
+	if err := job.seekSource(ctx, sourceClient, sourceSub); err != nil {
+		return 0, err
+	}
+	defer job.saveDedupKeys(context.WithoutCancel(ctx))
+
 	// Set receive settings for better performance
 	sourceSub.ReceiveSettings.Synchronous = false
 	sourceSub.ReceiveSettings.NumGoroutines = 10
 	sourceSub.ReceiveSettings.MaxOutstandingMessages = 100
 
+	// Keep the ordering keys of source messages, which requires ordered publishing
+	targetTopic.EnableMessageOrdering = true
+	pauses := newOrderingPauses()
+
+	// Block publishing when the target falls behind. Messages are only acked
+	// after their publish completed, so this also throttles receiving.
+	targetTopic.PublishSettings.FlowControlSettings = pubsub.FlowControlSettings{
+		MaxOutstandingMessages: 1000,
+		MaxOutstandingBytes:    100 * 1024 * 1024,
+		LimitExceededBehavior:  pubsub.FlowControlBlock,
+	}
+	defer job.stopTopic(targetTopic)
+
 	// Determine number of messages to process
+	continuous := req.Mode == ModeContinuous
 	maxMessages := req.NumMessages
 	if req.AllMessages {
 		// For "all messages", we'll set a high limit and process until no more messages
@@ -155,126 +283,278 @@ func processShovelRequest(ctx context.Context, req *ShovelRequest) (int, error)
 	}
 
 	// Process messages with proper concurrency control
-	var acceptedCount int  // Messages accepted for processing
-	var processedCount int // Messages successfully processed
-	var mutex sync.Mutex
-	done := make(chan bool)
+	done := make(chan error, 1)
+	// outstanding tracks messages whose publish or ack result is still awaited
+	var outstanding sync.WaitGroup
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	go func() {
-		err = sourceSub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-			mutex.Lock()
+		err := sourceSub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+			job.metrics.receivedMessage(len(msg.Data))
+
+			// Acknowledge messages that were published before. A message
+			// whose publish is still running is handed back: acking it
+			// would lose it if that publish fails.
+			key := job.dedupKey(msg)
+			if key != "" {
+				switch job.dedup.claim(key) {
+				case dedupDuplicate:
+					// A duplicate whose ack fails is redelivered and skipped again
+					outstanding.Add(1)
+					go func() {
+						defer outstanding.Done()
+						if err := traceStage(ctx, "shovel.ack", job.ackMessage(msg)); err != nil {
+							job.logMessage(ctx, "Failed to acknowledge duplicate message", msg.ID, "error", err)
+							return
+						}
+						job.recordDuplicate()
+						job.logMessage(ctx, "Acknowledged duplicate message", msg.ID, "dedupKey", key)
+					}()
+					return
+				case dedupPending:
+					job.nackMessage(ctx, msg)()
+					job.metrics.nacked.Inc()
+					return
+				}
+			}
+
+			// Hand back messages whose ordering key waits for the redelivery
+			// of a failed message
+			admitted, resume := pauses.admit(msg)
+			if !admitted {
+				if key != "" {
+					job.dedup.release(key)
+				}
+				job.nackMessage(ctx, msg)()
+				job.metrics.nacked.Inc()
+				return
+			}
+			if resume {
+				targetTopic.ResumePublish(msg.OrderingKey)
+			}
+
 			// Check if we've already accepted enough messages
-			if acceptedCount >= maxMessages {
-				mutex.Unlock()
-				msg.Nack()
+			if !job.tryAccept(maxMessages) {
+				if key != "" {
+					job.dedup.release(key)
+				}
+				job.nackMessage(ctx, msg)()
+				job.metrics.nacked.Inc()
+				job.setStopReason(StopReasonLimitReached)
 				cancel()
 				return
 			}
-			// Increment accepted count immediately to prevent race condition
-			acceptedCount++
-			// currentAccepted := acceptedCount
-			mutex.Unlock()
 
-			//log.Printf("Accepted message %d/%d for processing", currentAccepted, maxMessages)
+			msgCtx, msgSpan := job.startMessageSpan(ctx, msg.ID, len(msg.Data), msg.Attributes)
+			job.logMessage(msgCtx, "Accepted message", msg.ID, "size", len(msg.Data))
+			attributes := job.withSourceMessageID(job.outgoingAttributes(msgCtx, msg.Attributes), msg)
 
 			// Publish to target topic
+			publishStart := time.Now()
+			publishSpan := startPublishSpan(msgCtx)
 			result := targetTopic.Publish(ctx, &pubsub.Message{
-				Data:       msg.Data,
-				Attributes: msg.Attributes,
+				Data:        msg.Data,
+				Attributes:  attributes,
+				OrderingKey: msg.OrderingKey,
 			})
 
-			// Wait for publish result
+			// Wait for publish result. This deliberately outlives ctx so that a
+			// cancelled job still acks what it already published.
+			outstanding.Add(1)
 			go func() {
-				_, publishErr := result.Get(ctx)
-				if publishErr != nil {
-					//log.Printf("Failed to publish message: %v", publishErr)
-					msg.Nack()
+				defer outstanding.Done()
+				publishCtx := job.publishContext()
+				_, publishErr := result.Get(publishCtx)
+				endSpan(publishSpan, publishErr)
+				defer endSpan(msgSpan, publishErr)
+				if publishErr != nil && publishCtx.Err() != nil {
+					// Publish aborted during shutdown, hand the message back right away
+					if key != "" {
+						job.dedup.release(key)
+					}
+					traceStage(msgCtx, "shovel.nack", job.nackMessage(msgCtx, msg))
+					job.recordNacked()
+					job.logMessage(msgCtx, "Nacked message after aborted publish", msg.ID)
+				} else if publishErr != nil {
+					job.logMessage(msgCtx, "Failed to publish message", msg.ID, "error", publishErr)
+					if key != "" {
+						job.dedup.release(key)
+					}
+					// A failed publish pauses its ordering key until the
+					// source redelivers the message
+					pauses.pause(msg, publishErr)
+					traceStage(msgCtx, "shovel.nack", job.nackMessage(msgCtx, msg))
+					job.metrics.nacked.Inc()
+					job.recordFailed(publishErr)
 					// Don't decrement acceptedCount since we want to stop at the limit
 				} else {
-					// Acknowledge original message
-					msg.Ack()
-					mutex.Lock()
-					processedCount++
-					//currentProcessed := processedCount
-					mutex.Unlock()
-					//log.Printf("Successfully processed message %d/%d (accepted: %d)", currentProcessed, maxMessages, currentAccepted)
+					job.metrics.publishedMessage(time.Since(publishStart))
+					if key != "" {
+						job.dedup.commit(key)
+					}
+					// Acknowledge original message. Exactly-once subscriptions
+					// confirm the ack before the message counts as processed.
+					if err := traceStage(msgCtx, "shovel.ack", job.ackMessage(msg)); err != nil {
+						job.recordAckFailed(err)
+						job.log(msgCtx, slog.LevelWarn, "Failed to acknowledge published message", "messageId", msg.ID, "error", err)
+						return
+					}
+					job.recordProcessed()
+					job.auditMessage(msg.ID)
+					job.logMessage(msgCtx, "Processed message", msg.ID)
 				}
 			}()
 		})
 
 		if err != nil {
-			log.Printf("Receive error: %v", err)
+			job.log(ctx, slog.LevelError, "Receive failed", "error", err)
 		}
-		done <- true
+		done <- err
 	}()
 
-	// Set timeout for processing
-	timeout := 5 * time.Minute
-	if req.AllMessages {
-		timeout = 10 * time.Minute // Longer timeout for "all messages"
+	// Set timeout for processing. Continuous jobs run until cancelled.
+	var timeoutC <-chan time.Time
+	if !continuous {
+		timeout := oneShotTimeout
+		if req.AllMessages {
+			timeout = allMessagesTimeout
+		}
+		timeoutC = time.After(timeout)
 	}
 
+	var receiveErr error
 	select {
-	case <-done:
-		log.Printf("Message processing completed")
-	case <-time.After(timeout):
-		log.Printf("Processing timeout reached")
+	case receiveErr = <-done:
+		job.log(ctx, slog.LevelInfo, "Message processing completed")
+	case <-timeoutC:
+		job.log(ctx, slog.LevelWarn, "Processing timeout reached")
+		job.setStopReason(StopReasonTimeout)
 		cancel()
+		// Receive returns once every outstanding message was acked or nacked
+		receiveErr = <-done
 	}
+	// Acks are confirmed once Receive flushed them
+	outstanding.Wait()
 
-	// Wait a bit for any pending operations to complete
-	time.Sleep(2 * time.Second)
-
-	mutex.Lock()
-	finalAccepted := acceptedCount
-	finalProcessed := processedCount
-	mutex.Unlock()
+	status := job.Status()
+	job.log(ctx, slog.LevelInfo, "Shovel completed")
 
-	log.Printf("Shovel completed: accepted %d messages, successfully processed %d messages", finalAccepted, finalProcessed)
-	return finalProcessed, nil
+	// Receive returns nil when the job stops it, an error means receiving broke
+	// down, e.g. because the subscription was deleted or access was revoked
+	if receiveErr != nil {
+		return status.ProcessedCount, fmt.Errorf("receive failed: %v", receiveErr)
+	}
+	return status.ProcessedCount, nil
 }
 
-// extractProjectID extracts project ID from a resource name
-func extractProjectID(resourceName string) string {
-	parts := splitResourceName(resourceName)
-	for i, part := range parts {
-		if part == "projects" && i+1 < len(parts) {
-			return parts[i+1]
+// StatusHandler returns the status of the job given by the jobId query parameter
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if handleCORS(w, r, "GET") {
+		return
+	}
+	r, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	jobID := jobIDFromRequest(r)
+	var status JobStatus
+	if job := jobs.get(jobID); job != nil {
+		status = job.Status()
+	} else {
+		// Fall back to jobs recorded by earlier instances
+		record, err := jobStore.Get(r.Context(), jobID)
+		if errors.Is(err, ErrJobNotFound) {
+			respondWithError(w, "Job not found", http.StatusNotFound)
+			return
 		}
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Failed to load job: %v", err), http.StatusInternalServerError)
+			return
+		}
+		status = record.Status
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Error("Failed to encode response", "error", err)
 	}
-	return ""
 }
 
-// extractResourceName extracts the resource name from FQDN
-func extractResourceName(fqdn string) string {
-	parts := splitResourceName(fqdn)
-	if len(parts) > 0 {
-		return parts[len(parts)-1]
+// CancelHandler cancels the job given by the jobId query parameter
+func CancelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if handleCORS(w, r, "POST") {
+		return
+	}
+	r, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method != "POST" {
+		respondWithError(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := jobIDFromRequest(r)
+	job := jobs.get(jobID)
+	if job == nil {
+		if record, err := jobStore.Get(r.Context(), jobID); err == nil {
+			respondWithError(w, fmt.Sprintf("Job %s is %s and not running on this instance", jobID, record.Status.State), http.StatusConflict)
+			return
+		}
+		respondWithError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if !job.Cancel() {
+		respondWithError(w, fmt.Sprintf("Job %s is already %s", jobID, job.Status().State), http.StatusConflict)
+		return
+	}
+
+	job.log(r.Context(), slog.LevelInfo, "Cancelling request")
+	response := ShovelResponse{
+		Status:    "cancelling",
+		Message:   "Job cancellation requested",
+		RequestID: jobID,
+	}
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to encode response", "error", err)
 	}
-	return ""
 }
 
-// splitResourceName splits a resource name by '/'
-func splitResourceName(name string) []string {
-	result := []string{}
-	current := ""
-	for _, char := range name {
-		if char == '/' {
-			if current != "" {
-				result = append(result, current)
-				current = ""
-			}
-		} else {
-			current += string(char)
+// HealthHandler reports 503 if any running job is stalled
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var stalled []string
+	for _, job := range jobs.list() {
+		if status := job.Status(); !status.Healthy {
+			stalled = append(stalled, status.ID)
 		}
 	}
-	if current != "" {
-		result = append(result, current)
+	if len(stalled) > 0 {
+		respondWithError(w, fmt.Sprintf("Stalled jobs: %s", strings.Join(stalled, ", ")), http.StatusServiceUnavailable)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(ShovelResponse{Status: "ok", Message: "All jobs healthy"}); err != nil {
+		logger.Error("Failed to encode response", "error", err)
 	}
-	return result
+}
+
+// newRequestID generates a request ID from the current time
+func newRequestID() string {
+	return fmt.Sprintf("shovel-%d", time.Now().UnixNano()/1000000)
+}
+
+// jobIDFromRequest returns the job ID from the {id} path segment or the jobId query parameter
+func jobIDFromRequest(r *http.Request) string {
+	if id := r.PathValue("id"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("jobId")
 }
 
 // respondWithError sends an error response
@@ -285,10 +565,15 @@ func respondWithError(w http.ResponseWriter, message string, statusCode int) {
 	}
 	w.WriteHeader(statusCode)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Failed to encode error response: %v", err)
+		logger.Error("Failed to encode error response", "error", err)
 	}
 }
 
+// newPubsubClient creates the Pub/Sub client for a project
+var newPubsubClient = func(ctx context.Context, projectID string, opts ...option.ClientOption) (*pubsub.Client, error) {
+	return pubsub.NewClient(ctx, projectID, opts...)
+}
+
 // GetEnvVar is a helper to get environment variables with fallback
 func GetEnvVar(key string) string {
 	return os.Getenv(key)
@@ -9,6 +9,9 @@ import (
 )
 
 func TestHandler_ValidationErrors(t *testing.T) {
+	// Valid requests pass the preflight checks against the fake server
+	useTestServer(t, newShovelFixture(t, 0))
+
 	tests := []struct {
 		name         string
 		payload      ShovelRequest
@@ -50,19 +53,66 @@ func TestHandler_ValidationErrors(t *testing.T) {
 			expectedCode: http.StatusBadRequest,
 		},
 		{
-			name: "valid request with numMessages",
+			name: "continuous mode with numMessages",
+			payload: ShovelRequest{
+				NumMessages:        10,
+				Mode:               ModeContinuous,
+				SourceSubscription: "projects/test/subscriptions/source",
+				TargetTopic:        "projects/test/topics/target",
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "unknown mode",
 			payload: ShovelRequest{
 				NumMessages:        10,
+				Mode:               "forever",
 				SourceSubscription: "projects/test/subscriptions/source",
 				TargetTopic:        "projects/test/topics/target",
 			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "malformed source subscription",
+			payload: ShovelRequest{
+				NumMessages:        10,
+				SourceSubscription: "invalid-format/source",
+				TargetTopic:        "projects/test/topics/target",
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "subscription passed as target topic",
+			payload: ShovelRequest{
+				NumMessages:        10,
+				SourceSubscription: "projects/test/subscriptions/source",
+				TargetTopic:        "projects/test/subscriptions/target",
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "topic passed as source subscription",
+			payload: ShovelRequest{
+				NumMessages:        10,
+				SourceSubscription: "projects/test/topics/source",
+				TargetTopic:        "projects/test/topics/target",
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "valid request with numMessages",
+			payload: ShovelRequest{
+				NumMessages:        10,
+				SourceSubscription: "projects/test/subscriptions/source-sub",
+				TargetTopic:        "projects/test/topics/target",
+			},
 			expectedCode: http.StatusAccepted,
 		},
 		{
 			name: "valid request with allMessages",
 			payload: ShovelRequest{
 				AllMessages:        true,
-				SourceSubscription: "projects/test/subscriptions/source",
+				SourceSubscription: "projects/test/subscriptions/source-sub",
 				TargetTopic:        "projects/test/topics/target",
 			},
 			expectedCode: http.StatusAccepted,
@@ -81,6 +131,13 @@ func TestHandler_ValidationErrors(t *testing.T) {
 			if rr.Code != tt.expectedCode {
 				t.Errorf("Expected status code %d, got %d", tt.expectedCode, rr.Code)
 			}
+			var response ShovelResponse
+			if json.NewDecoder(rr.Body).Decode(&response) == nil {
+				if job := jobs.get(response.RequestID); job != nil {
+					job.Cancel()
+					<-job.Done()
+				}
+			}
 		})
 	}
 }
@@ -110,7 +167,7 @@ func TestHandler_OptionsRequest(t *testing.T) {
 	expectedHeaders := map[string]string{
 		"Access-Control-Allow-Origin":  "*",
 		"Access-Control-Allow-Methods": "POST, OPTIONS",
-		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Allow-Headers": "Content-Type, Authorization, X-API-Key",
 	}
 
 	for header, expectedValue := range expectedHeaders {
@@ -119,61 +176,3 @@ func TestHandler_OptionsRequest(t *testing.T) {
 		}
 	}
 }
-
-func TestExtractProjectID(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{
-			input:    "projects/my-project/subscriptions/my-sub",
-			expected: "my-project",
-		},
-		{
-			input:    "projects/another-project/topics/my-topic",
-			expected: "another-project",
-		},
-		{
-			input:    "invalid-format",
-			expected: "",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			result := extractProjectID(tt.input)
-			if result != tt.expected {
-				t.Errorf("Expected %s, got %s", tt.expected, result)
-			}
-		})
-	}
-}
-
-func TestExtractResourceName(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{
-			input:    "projects/my-project/subscriptions/my-subscription",
-			expected: "my-subscription",
-		},
-		{
-			input:    "projects/my-project/topics/my-topic",
-			expected: "my-topic",
-		},
-		{
-			input:    "just-a-name",
-			expected: "just-a-name",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			result := extractResourceName(tt.input)
-			if result != tt.expected {
-				t.Errorf("Expected %s, got %s", tt.expected, result)
-			}
-		})
-	}
-}
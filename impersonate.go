@@ -0,0 +1,62 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"cloud.google.com/go/pubsub"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/impersonate"
+)
+
+// DefaultIdentity is recorded for sides of a job that use the shovel's own credentials
+const DefaultIdentity = "default"
+
+// serviceAccountPattern matches service account emails, including the
+// default compute service account
+var serviceAccountPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*@[a-z0-9][a-z0-9.-]*\.gserviceaccount\.com$`)
+
+// newImpersonatedTokenSource returns tokens of serviceAccount. The shovel's
+// own identity needs roles/iam.serviceAccountTokenCreator on it.
+var newImpersonatedTokenSource = func(ctx context.Context, serviceAccount string) (oauth2.TokenSource, error) {
+	return impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: serviceAccount,
+		Scopes:          []string{pubsub.ScopePubSub},
+	})
+}
+
+// validateServiceAccounts checks the service accounts to impersonate
+func validateServiceAccounts(req *ShovelRequest) error {
+	if req.SourceServiceAccount != "" {
+		if req.SourceKafka != nil {
+			return fmt.Errorf("sourceServiceAccount cannot be used with sourceKafka")
+		}
+		if !serviceAccountPattern.MatchString(req.SourceServiceAccount) {
+			return fmt.Errorf("sourceServiceAccount %q is not a service account email", req.SourceServiceAccount)
+		}
+	}
+	if req.TargetServiceAccount != "" && !serviceAccountPattern.MatchString(req.TargetServiceAccount) {
+		return fmt.Errorf("targetServiceAccount %q is not a service account email", req.TargetServiceAccount)
+	}
+	return nil
+}
+
+// serviceAccounts returns the service accounts req impersonates
+func (req ShovelRequest) serviceAccounts() []string {
+	var accounts []string
+	for _, account := range []string{req.SourceServiceAccount, req.TargetServiceAccount} {
+		if account != "" {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// identity returns the identity recorded for one side of a job
+func identity(serviceAccount string) string {
+	if serviceAccount == "" {
+		return DefaultIdentity
+	}
+	return serviceAccount
+}
@@ -0,0 +1,128 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// stubImpersonation records the impersonated service accounts for the rest of the test
+func stubImpersonation(t *testing.T) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var accounts []string
+	original := newImpersonatedTokenSource
+	newImpersonatedTokenSource = func(ctx context.Context, serviceAccount string) (oauth2.TokenSource, error) {
+		if serviceAccount == "broken@test.iam.gserviceaccount.com" {
+			return nil, errors.New("permission denied")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		accounts = append(accounts, serviceAccount)
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token-of-" + serviceAccount}), nil
+	}
+	t.Cleanup(func() { newImpersonatedTokenSource = original })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), accounts...)
+	}
+}
+
+func TestValidateServiceAccounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         ShovelRequest
+		expectError bool
+	}{
+		{name: "none", req: ShovelRequest{}},
+		{name: "both", req: ShovelRequest{SourceServiceAccount: "reader@team-a.iam.gserviceaccount.com", TargetServiceAccount: "writer@team-b.iam.gserviceaccount.com"}},
+		{name: "default compute", req: ShovelRequest{TargetServiceAccount: "123456789-compute@developer.gserviceaccount.com"}},
+		{name: "user account", req: ShovelRequest{SourceServiceAccount: "jane@example.com"}, expectError: true},
+		{name: "not an email", req: ShovelRequest{TargetServiceAccount: "writer"}, expectError: true},
+		{name: "kafka source", req: ShovelRequest{SourceKafka: &KafkaSource{}, SourceServiceAccount: "reader@team-a.iam.gserviceaccount.com"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateServiceAccounts(&tt.req)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClientCache_Impersonation(t *testing.T) {
+	srv, _ := newTestClient(t)
+	useTestServer(t, srv)
+	impersonated := stubImpersonation(t)
+
+	own, err := pubsubClient(context.Background(), "project-a", "")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	reader, err := pubsubClient(context.Background(), "project-a", "reader@team-a.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	again, _ := pubsubClient(context.Background(), "project-a", "reader@team-a.iam.gserviceaccount.com")
+	if own == reader {
+		t.Error("Expected separate clients per identity")
+	}
+	if reader != again {
+		t.Error("Expected the impersonated client to be reused")
+	}
+	if got := impersonated(); len(got) != 1 || got[0] != "reader@team-a.iam.gserviceaccount.com" {
+		t.Errorf("Expected a single impersonation of the reader, got %v", got)
+	}
+
+	if _, err := pubsubClient(context.Background(), "project-a", "broken@test.iam.gserviceaccount.com"); err == nil {
+		t.Error("Expected impersonation failures to be returned")
+	}
+}
+
+func TestShovel_Impersonation(t *testing.T) {
+	srv := newShovelFixture(t, 5)
+	useTestServer(t, srv)
+	impersonated := stubImpersonation(t)
+
+	job := startJob(context.Background(), "shovel-impersonation-test", ShovelRequest{
+		NumMessages:          3,
+		SourceSubscription:   "projects/test/subscriptions/source-sub",
+		TargetTopic:          "projects/test/topics/target",
+		SourceServiceAccount: "reader@team-a.iam.gserviceaccount.com",
+		TargetServiceAccount: "writer@team-b.iam.gserviceaccount.com",
+	})
+	<-job.Done()
+
+	status := job.Status()
+	if status.State != JobStateCompleted || status.ProcessedCount != 3 {
+		t.Fatalf("Expected 3 messages to be moved, got %+v", status)
+	}
+	if status.SourceIdentity != "reader@team-a.iam.gserviceaccount.com" || status.TargetIdentity != "writer@team-b.iam.gserviceaccount.com" {
+		t.Errorf("Expected impersonated identities in the status, got %s and %s", status.SourceIdentity, status.TargetIdentity)
+	}
+	got := impersonated()
+	if len(got) != 2 {
+		t.Errorf("Expected both service accounts to be impersonated, got %v", got)
+	}
+}
+
+func TestJobStatus_Identities(t *testing.T) {
+	status := newJob("shovel-identity-test", ShovelRequest{}).Status()
+	if status.SourceIdentity != DefaultIdentity || status.TargetIdentity != DefaultIdentity {
+		t.Errorf("Expected default identities, got %s and %s", status.SourceIdentity, status.TargetIdentity)
+	}
+
+	status = newJob("shovel-identity-kafka-test", ShovelRequest{SourceKafka: &KafkaSource{Topic: "orders"}}).Status()
+	if status.SourceIdentity != "" {
+		t.Errorf("Expected no source identity for Kafka sources, got %s", status.SourceIdentity)
+	}
+}
@@ -0,0 +1,372 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// integrationIdle is how long draining a subscription waits for more messages
+	integrationIdle = time.Second
+	// drainTimeout bounds draining a subscription, it exceeds the ack deadline
+	drainTimeout = 30 * time.Second
+	// redeliveryTimeout bounds draining the source subscription. A message
+	// the server sent to the shovel's stream while Receive stopped is never
+	// seen by the client and only comes back once the stream ack deadline of
+	// 60 seconds expired.
+	redeliveryTimeout = 70 * time.Second
+)
+
+// integrationEnv runs shovel jobs end-to-end against an in-process fake
+// server, or against the Pub/Sub emulator at PUBSUB_EMULATOR_HOST when set.
+// Every test gets its own source topic and subscription plus a target topic
+// with a subscription that captures what the shovel published.
+type integrationEnv struct {
+	t         *testing.T
+	srv       *pstest.Server // nil when running against the emulator
+	client    *pubsub.Client
+	source    *pubsub.Topic
+	sourceSub *pubsub.Subscription
+	target    *pubsub.Topic
+	targetSub *pubsub.Subscription
+	sourceIDs map[string]string // Data of the published source messages by ID
+}
+
+// skipInShortMode skips the end-to-end and fault injection tests with -short
+func skipInShortMode(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+}
+
+// newIntegrationEnv creates the resources of a test. opts apply to the
+// clients of the shovel only, not to the client the test uses to publish
+// and inspect messages.
+func newIntegrationEnv(t *testing.T, opts ...grpc.DialOption) *integrationEnv {
+	t.Helper()
+	skipInShortMode(t)
+	ctx := context.Background()
+	var srv *pstest.Server
+	addr := os.Getenv("PUBSUB_EMULATOR_HOST")
+	if addr == "" {
+		srv = pstest.NewServer()
+		t.Cleanup(func() { srv.Close() })
+		addr = srv.Addr
+	}
+	useServerAddr(t, addr, opts...)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	client, err := pubsub.NewClient(ctx, "test", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// Names are unique so that runs against a long-lived emulator do not collide
+	prefix := "it-" + regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(t.Name()), "-") + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	e := &integrationEnv{t: t, srv: srv, client: client, sourceIDs: map[string]string{}}
+	e.source = e.createTopic(prefix + "-source")
+	e.source.EnableMessageOrdering = true
+	e.target = e.createTopic(prefix + "-target")
+	e.sourceSub = e.createSubscription(prefix+"-source-sub", e.source)
+	e.targetSub = e.createSubscription(prefix+"-target-sub", e.target)
+	return e
+}
+
+func (e *integrationEnv) createTopic(id string) *pubsub.Topic {
+	e.t.Helper()
+	topic, err := e.client.CreateTopic(context.Background(), id)
+	if err != nil {
+		e.t.Fatalf("Failed to create topic: %v", err)
+	}
+	e.t.Cleanup(func() {
+		topic.Stop()
+		topic.Delete(context.Background())
+	})
+	return topic
+}
+
+func (e *integrationEnv) createSubscription(id string, topic *pubsub.Topic) *pubsub.Subscription {
+	e.t.Helper()
+	sub, err := e.client.CreateSubscription(context.Background(), id, pubsub.SubscriptionConfig{Topic: topic, EnableMessageOrdering: true})
+	if err != nil {
+		e.t.Fatalf("Failed to create subscription: %v", err)
+	}
+	e.t.Cleanup(func() { sub.Delete(context.Background()) })
+	return sub
+}
+
+// publish publishes msgs to the source topic and waits until all are stored
+func (e *integrationEnv) publish(msgs ...*pubsub.Message) {
+	e.t.Helper()
+	ctx := context.Background()
+	results := make([]*pubsub.PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = e.source.Publish(ctx, msg)
+	}
+	for i, result := range results {
+		id, err := result.Get(ctx)
+		if err != nil {
+			e.t.Fatalf("Failed to publish: %v", err)
+		}
+		e.sourceIDs[id] = string(msgs[i].Data)
+	}
+}
+
+// publishN publishes n messages "message-<i>" with attribute index=<i>. Each
+// message has its own ordering key, so the shovel publishes every message in
+// a request of its own.
+func (e *integrationEnv) publishN(n int) {
+	e.t.Helper()
+	msgs := make([]*pubsub.Message, n)
+	for i := range msgs {
+		msgs[i] = &pubsub.Message{
+			Data:        []byte(fmt.Sprintf("message-%d", i)),
+			Attributes:  map[string]string{"index": strconv.Itoa(i)},
+			OrderingKey: fmt.Sprintf("key-%d", i),
+		}
+	}
+	e.publish(msgs...)
+}
+
+// run executes req from the source subscription to the target topic and
+// returns the final job status
+func (e *integrationEnv) run(req ShovelRequest) JobStatus {
+	e.t.Helper()
+	req.SourceSubscription = e.sourceSub.String()
+	req.TargetTopic = e.target.String()
+	if err := validateRequest(context.Background(), &req); err != nil {
+		e.t.Fatalf("Invalid request: %v", err)
+	}
+	job := newJob(newRequestID(), req)
+	job.run(context.Background())
+	return job.Status()
+}
+
+// published drains the messages the shovel published, waiting for at least
+// want of them
+func (e *integrationEnv) published(want int) []*pubsub.Message {
+	e.t.Helper()
+	return e.drain(e.targetSub, want, drainTimeout)
+}
+
+// remaining drains the messages left on the source subscription, i.e. those
+// the shovel did not acknowledge, waiting for at least want of them. Messages
+// the shovel received but never handed to its callback only come back once
+// their ack deadline expired.
+func (e *integrationEnv) remaining(want int) []*pubsub.Message {
+	e.t.Helper()
+	return e.drain(e.sourceSub, want, redeliveryTimeout)
+}
+
+// drain acknowledges and returns the messages of sub. It stops once want
+// messages arrived and no more followed for integrationIdle, or after timeout.
+func (e *integrationEnv) drain(sub *pubsub.Subscription, want int, timeout time.Duration) []*pubsub.Message {
+	e.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wait := timeout
+	if want == 0 {
+		wait = integrationIdle
+	}
+	idle := time.AfterFunc(wait, cancel)
+	defer idle.Stop()
+
+	var mu sync.Mutex
+	var msgs []*pubsub.Message
+	err := sub.Receive(ctx, func(_ context.Context, msg *pubsub.Message) {
+		msg.Ack()
+		mu.Lock()
+		defer mu.Unlock()
+		msgs = append(msgs, msg)
+		if len(msgs) >= want {
+			idle.Reset(integrationIdle)
+		}
+	})
+	if err != nil {
+		e.t.Fatalf("Failed to drain %s: %v", sub, err)
+	}
+	return msgs
+}
+
+// useTimeouts shortens the processing timeouts of one-shot jobs
+func useTimeouts(t *testing.T, oneShot, allMessages time.Duration) {
+	originalOneShot, originalAll := oneShotTimeout, allMessagesTimeout
+	oneShotTimeout, allMessagesTimeout = oneShot, allMessages
+	t.Cleanup(func() { oneShotTimeout, allMessagesTimeout = originalOneShot, originalAll })
+}
+
+// failPublish fails every Publish RPC with code
+func failPublish(code codes.Code) grpc.DialOption {
+	return grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if strings.HasSuffix(method, "/Publish") {
+			return status.Error(code, "injected failure")
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+// dataSet returns the payloads of msgs, failing the test on duplicates
+func dataSet(t *testing.T, msgs []*pubsub.Message) map[string]bool {
+	t.Helper()
+	set := map[string]bool{}
+	for _, msg := range msgs {
+		if set[string(msg.Data)] {
+			t.Errorf("Duplicate message %s", msg.Data)
+		}
+		set[string(msg.Data)] = true
+	}
+	return set
+}
+
+func TestIntegration_NumMessages(t *testing.T) {
+	e := newIntegrationEnv(t)
+	e.publishN(10)
+
+	status := e.run(ShovelRequest{NumMessages: 4})
+	if status.State != JobStateCompleted || status.StopReason != StopReasonLimitReached {
+		t.Errorf("Expected completed job stopped by the limit, got %s/%s", status.State, status.StopReason)
+	}
+	if status.ProcessedCount != 4 || status.FailedCount != 0 {
+		t.Errorf("Expected 4 processed and none failed, got %d and %d", status.ProcessedCount, status.FailedCount)
+	}
+
+	published := e.published(4)
+	if len(published) != 4 {
+		t.Fatalf("Expected 4 published messages, got %d", len(published))
+	}
+	for _, msg := range published {
+		if want := "message-" + msg.Attributes["index"]; string(msg.Data) != want {
+			t.Errorf("Expected attributes to travel with the data, got %q with index %q", msg.Data, msg.Attributes["index"])
+		}
+	}
+
+	// Every message was either moved and acked, or handed back to the source
+	remaining := e.remaining(6)
+	if len(remaining) != 6 {
+		t.Errorf("Expected 6 messages left on the source, got %d", len(remaining))
+	}
+	moved, left := dataSet(t, published), dataSet(t, remaining)
+	for i := 0; i < 10; i++ {
+		data := fmt.Sprintf("message-%d", i)
+		if moved[data] == left[data] {
+			t.Errorf("Expected %s either moved or left on the source, moved=%v left=%v", data, moved[data], left[data])
+		}
+	}
+}
+
+func TestIntegration_AllMessages(t *testing.T) {
+	useTimeouts(t, time.Minute, 3*time.Second)
+	e := newIntegrationEnv(t)
+	e.publishN(25)
+
+	// allMessages keeps receiving until its timeout
+	status := e.run(ShovelRequest{AllMessages: true})
+	if status.State != JobStateCompleted || status.StopReason != StopReasonTimeout {
+		t.Errorf("Expected completed job stopped by the timeout, got %s/%s", status.State, status.StopReason)
+	}
+	if status.ProcessedCount != 25 {
+		t.Errorf("Expected 25 processed messages, got %d", status.ProcessedCount)
+	}
+	if moved := dataSet(t, e.published(25)); len(moved) != 25 {
+		t.Errorf("Expected 25 published messages, got %d", len(moved))
+	}
+	if remaining := e.remaining(0); len(remaining) != 0 {
+		t.Errorf("Expected the source to be empty, got %d messages", len(remaining))
+	}
+}
+
+func TestIntegration_Ordering(t *testing.T) {
+	useTimeouts(t, time.Minute, 3*time.Second)
+	e := newIntegrationEnv(t)
+	keys := []string{"customer-1", "customer-2", "customer-3"}
+	var msgs []*pubsub.Message
+	for i := 0; i < 10; i++ {
+		for _, key := range keys {
+			msgs = append(msgs, &pubsub.Message{Data: []byte(fmt.Sprintf("%s-%d", key, i)), OrderingKey: key})
+		}
+	}
+	e.publish(msgs...)
+
+	status := e.run(ShovelRequest{AllMessages: true})
+	if status.ProcessedCount != len(msgs) {
+		t.Fatalf("Expected %d processed messages, got %d", len(msgs), status.ProcessedCount)
+	}
+
+	sequences := map[string][]string{}
+	for _, msg := range e.published(len(msgs)) {
+		sequences[msg.OrderingKey] = append(sequences[msg.OrderingKey], string(msg.Data))
+	}
+	for _, key := range keys {
+		var want []string
+		for i := 0; i < 10; i++ {
+			want = append(want, fmt.Sprintf("%s-%d", key, i))
+		}
+		if strings.Join(sequences[key], ",") != strings.Join(want, ",") {
+			t.Errorf("Expected %s in order %v, got %v", key, want, sequences[key])
+		}
+	}
+}
+
+func TestIntegration_PublishFailure(t *testing.T) {
+	e := newIntegrationEnv(t, failPublish(codes.PermissionDenied))
+	e.publishN(5)
+
+	status := e.run(ShovelRequest{NumMessages: 5})
+	if status.ProcessedCount != 0 || status.FailedCount != 5 {
+		t.Errorf("Expected 5 failed and none processed, got %d failed and %d processed", status.FailedCount, status.ProcessedCount)
+	}
+	if status.ErrorCounts["PermissionDenied"] != 5 {
+		t.Errorf("Expected failures counted as PermissionDenied, got %v", status.ErrorCounts)
+	}
+	if published := e.published(0); len(published) != 0 {
+		t.Errorf("Expected nothing published, got %d messages", len(published))
+	}
+	// Failed messages are nacked and stay on the source
+	if remaining := dataSet(t, e.remaining(5)); len(remaining) != 5 {
+		t.Errorf("Expected all 5 messages left on the source, got %d", len(remaining))
+	}
+}
+
+func TestIntegration_Timeout(t *testing.T) {
+	useTimeouts(t, 2*time.Second, time.Minute)
+	e := newIntegrationEnv(t)
+	e.publishN(3)
+
+	start := time.Now()
+	status := e.run(ShovelRequest{NumMessages: 10})
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("Expected the job to end after its timeout, took %v", elapsed)
+	}
+	if status.State != JobStateCompleted || status.StopReason != StopReasonTimeout {
+		t.Errorf("Expected completed job stopped by the timeout, got %s/%s", status.State, status.StopReason)
+	}
+	if status.ProcessedCount != 3 || status.InFlight != 0 {
+		t.Errorf("Expected 3 processed and none in flight, got %d and %d", status.ProcessedCount, status.InFlight)
+	}
+	if published := e.published(3); len(published) != 3 {
+		t.Errorf("Expected 3 published messages, got %d", len(published))
+	}
+	if remaining := e.remaining(0); len(remaining) != 0 {
+		t.Errorf("Expected the source to be empty, got %d messages", len(remaining))
+	}
+}
@@ -0,0 +1,570 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/status"
+)
+
+// Shovel modes
+const (
+	ModeOneShot    = "oneshot"    // Bounded job that stops after numMessages/allMessages
+	ModeContinuous = "continuous" // Keeps forwarding until explicitly cancelled
+)
+
+// progressInterval is how often running jobs log their progress
+const progressInterval = 30 * time.Second
+
+// stallThreshold is how long a job may have messages in flight without any
+// completing before it is reported as stalled
+const stallThreshold = 5 * time.Minute
+
+// abortGracePeriod is how long aborted jobs get to nack their in-flight messages
+const abortGracePeriod = 2 * time.Second
+
+// JobState describes the lifecycle state of a shovel job
+type JobState string
+
+// Job states
+const (
+	JobStateRunning   JobState = "running"
+	JobStateCompleted JobState = "completed"
+	JobStateFailed    JobState = "failed"
+	JobStateCancelled JobState = "cancelled"
+	// JobStateInterrupted marks a job stopped by an instance shutdown. It can
+	// be resumed from its stored spec.
+	JobStateInterrupted JobState = "interrupted"
+)
+
+// Stop reasons explain why a job ended
+const (
+	StopReasonLimitReached = "limit_reached" // numMessages (or the allMessages cap) was reached
+	StopReasonTimeout      = "timeout"       // The processing timeout expired
+	StopReasonSourceIdle   = "source_idle"   // No new messages arrived for a while
+	StopReasonCancelled    = "cancelled"     // Cancelled through the cancel endpoint
+	StopReasonShutdown     = "shutdown"      // The instance shut down
+	StopReasonError        = "error"         // The job failed
+	StopReasonCompleted    = "completed"     // The source stopped delivering on its own
+)
+
+// JobStatus is a point-in-time snapshot of a job
+type JobStatus struct {
+	ID                 string     `json:"id"`
+	Principal          string     `json:"principal,omitempty"`
+	State              JobState   `json:"state"`
+	Mode               string     `json:"mode"`
+	SourceSubscription string     `json:"sourceSubscription,omitempty"`
+	TargetTopic        string     `json:"targetTopic"`
+	SourceIdentity     string     `json:"sourceIdentity,omitempty"` // Service account used to receive, or "default"
+	TargetIdentity     string     `json:"targetIdentity"`           // Service account used to publish, or "default"
+	AcceptedCount      int        `json:"acceptedCount"`
+	ProcessedCount     int        `json:"processedCount"`
+	FailedCount        int        `json:"failedCount"`
+	NackedCount        int        `json:"nackedCount"`
+	DuplicateCount     int        `json:"duplicateCount,omitempty"` // Messages acknowledged without publishing because dedup found them published before
+	AckFailedCount     int        `json:"ackFailedCount,omitempty"` // Messages published whose ack was not confirmed
+	ExactlyOnce        bool       `json:"exactlyOnce,omitempty"`    // The source subscription confirms acks
+	Snapshot           string     `json:"snapshot,omitempty"`       // Snapshot of the source subscription taken before seeking
+	SeekedAt           *time.Time `json:"seekedAt,omitempty"`       // When the source subscription was seeked
+	InFlight           int        `json:"inFlight"`
+	Healthy            bool       `json:"healthy"`
+	StartedAt          time.Time  `json:"startedAt"`
+	LastProgressAt     time.Time  `json:"lastProgressAt"`
+	FinishedAt         *time.Time `json:"finishedAt,omitempty"`
+	StopReason         string     `json:"stopReason,omitempty"`
+	Error              string     `json:"error,omitempty"`
+	// ErrorCounts breaks failedCount down by gRPC status code
+	ErrorCounts map[string]int `json:"errorCounts,omitempty"`
+	// AckErrorCounts breaks ackFailedCount down by acknowledgement status
+	AckErrorCounts map[string]int `json:"ackErrorCounts,omitempty"`
+}
+
+// Job tracks a single shovel run and its counters
+type Job struct {
+	ID        string
+	Request   ShovelRequest
+	Principal Principal // Caller that started the job
+
+	mu           sync.Mutex
+	state        JobState
+	err          string
+	accepted     int
+	processed    int
+	failed       int
+	nacked       int
+	duplicates   int
+	ackFailed    int
+	errorCounts  map[string]int
+	stopReason   string
+	startedAt    time.Time
+	lastProgress time.Time
+	finishedAt   time.Time
+	cancelled    bool
+	interrupted  bool
+	transitions  []StateTransition
+	cancel       context.CancelFunc
+	done         chan struct{}
+	metrics      *jobMetrics
+	auditIDs     []string
+	dedup        *dedupCache // nil without dedup
+	exactlyOnce  bool        // Acks are confirmed by the source subscription
+	snapshot     string      // Snapshot taken before seeking
+	seekedAt     time.Time   // Zero until the source subscription was seeked
+
+	ackErrorCounts map[string]int
+
+	// publishCtx bounds waiting for outstanding publishes. It outlives the
+	// job context so that in-flight work can finish after a cancellation.
+	publishCtx     context.Context
+	abortPublishes context.CancelFunc
+}
+
+// newJob creates a job for the given request
+func newJob(id string, req ShovelRequest) *Job {
+	now := time.Now()
+	publishCtx, abortPublishes := context.WithCancel(context.Background())
+	var dedup *dedupCache
+	if req.Dedup != nil {
+		dedup = newDedupCache(req.Dedup.ttl(), req.Dedup.maxKeys())
+	}
+	return &Job{
+		ID:             id,
+		Request:        req,
+		state:          JobStateRunning,
+		startedAt:      now,
+		lastProgress:   now,
+		transitions:    []StateTransition{{State: JobStateRunning, At: now, Reason: "created"}},
+		done:           make(chan struct{}),
+		metrics:        newJobMetrics(req),
+		publishCtx:     publishCtx,
+		abortPublishes: abortPublishes,
+		dedup:          dedup,
+	}
+}
+
+// tryAccept reserves a slot for one more message, honouring max when it is positive
+func (j *Job) tryAccept(max int) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if max > 0 && j.accepted >= max {
+		return false
+	}
+	j.accepted++
+	return true
+}
+
+// recordAccepted counts a message that was taken on without a limit check
+func (j *Job) recordAccepted() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.accepted++
+}
+
+// recordProcessed counts a message that was published and acknowledged
+func (j *Job) recordProcessed() {
+	j.metrics.acked.Inc()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.processed++
+	j.lastProgress = time.Now()
+}
+
+// recordFailed counts a message that could not be forwarded because of err
+func (j *Job) recordFailed(err error) {
+	j.metrics.failed.Inc()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.failed++
+	j.lastProgress = time.Now()
+	if j.errorCounts == nil {
+		j.errorCounts = make(map[string]int)
+	}
+	j.errorCounts[status.Code(err).String()]++
+}
+
+// recordNacked counts a message handed back to the source because its
+// publish was aborted
+func (j *Job) recordNacked() {
+	j.metrics.nacked.Inc()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.nacked++
+}
+
+// setStopReason records why the job stopped unless a reason is already known
+func (j *Job) setStopReason(reason string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stopReason == "" {
+		j.stopReason = reason
+	}
+}
+
+// acceptedCount returns the number of messages taken on so far
+func (j *Job) acceptedCount() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.accepted
+}
+
+// Cancel stops the job. It returns false if the job already finished.
+func (j *Job) Cancel() bool {
+	return j.stop(false)
+}
+
+// interrupt stops the job because the instance shuts down
+func (j *Job) interrupt() bool {
+	return j.stop(true)
+}
+
+// stop cancels the job context, remembering why
+func (j *Job) stop(interrupted bool) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state != JobStateRunning {
+		return false
+	}
+	if interrupted {
+		j.interrupted = true
+	} else {
+		j.cancelled = true
+	}
+	if j.cancel != nil {
+		j.cancel()
+	}
+	return true
+}
+
+// publishContext returns the context used to wait for outstanding publishes
+func (j *Job) publishContext() context.Context {
+	return j.publishCtx
+}
+
+// stopTopic flushes the publisher of topic. Once publishes are aborted, it
+// stops waiting: a hanging publish must not hold up the final state of the job.
+func (j *Job) stopTopic(topic *pubsub.Topic) {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		topic.Stop()
+	}()
+	select {
+	case <-stopped:
+	case <-j.publishCtx.Done():
+	}
+}
+
+// Done returns a channel that is closed once the job reached its final state
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// finish records and persists the final state of the job
+func (j *Job) finish(err error) {
+	defer close(j.done)
+	j.abortPublishes()
+
+	j.mu.Lock()
+	j.finishedAt = time.Now()
+	switch {
+	case err != nil:
+		j.state = JobStateFailed
+		j.err = err.Error()
+		j.stopReason = StopReasonError
+	case j.cancelled:
+		j.state = JobStateCancelled
+		j.stopReason = StopReasonCancelled
+	case j.interrupted:
+		j.state = JobStateInterrupted
+		j.stopReason = StopReasonShutdown
+	default:
+		j.state = JobStateCompleted
+		if j.stopReason == "" {
+			j.stopReason = StopReasonCompleted
+		}
+	}
+	reason := j.err
+	if reason == "" {
+		reason = j.stopReason
+	}
+	j.transitions = append(j.transitions, StateTransition{State: j.state, At: j.finishedAt, Reason: reason})
+	j.mu.Unlock()
+
+	persisted := j.persist() == nil
+	j.notifyInBackground()
+	j.audit(AuditJobFinished)
+	if j.dedup != nil {
+		j.dedup.reset()
+	}
+	// Status and list requests fall back to the store for finished jobs
+	if persisted {
+		jobs.remove(j)
+	}
+}
+
+// stalledLocked reports whether messages are in flight without any progress
+func (j *Job) stalledLocked(now time.Time) bool {
+	inFlight := j.accepted - j.processed - j.failed - j.nacked - j.ackFailed
+	return j.state == JobStateRunning && inFlight > 0 && now.Sub(j.lastProgress) > stallThreshold
+}
+
+// Status returns a snapshot of the job
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	mode := j.Request.Mode
+	if mode == "" {
+		mode = ModeOneShot
+	}
+	status := JobStatus{
+		ID:                 j.ID,
+		Principal:          j.Principal.String(),
+		State:              j.state,
+		Mode:               mode,
+		SourceSubscription: j.Request.SourceSubscription,
+		TargetTopic:        j.Request.TargetTopic,
+		TargetIdentity:     identity(j.Request.TargetServiceAccount),
+		AcceptedCount:      j.accepted,
+		ProcessedCount:     j.processed,
+		FailedCount:        j.failed,
+		NackedCount:        j.nacked,
+		DuplicateCount:     j.duplicates,
+		AckFailedCount:     j.ackFailed,
+		ExactlyOnce:        j.exactlyOnce,
+		Snapshot:           j.snapshot,
+		InFlight:           j.accepted - j.processed - j.failed - j.nacked - j.ackFailed,
+		Healthy:            !j.stalledLocked(time.Now()),
+		StartedAt:          j.startedAt,
+		LastProgressAt:     j.lastProgress,
+		StopReason:         j.stopReason,
+		Error:              j.err,
+	}
+	if j.Request.SourceKafka == nil {
+		status.SourceIdentity = identity(j.Request.SourceServiceAccount)
+	}
+	if len(j.errorCounts) > 0 {
+		status.ErrorCounts = make(map[string]int, len(j.errorCounts))
+		for code, n := range j.errorCounts {
+			status.ErrorCounts[code] = n
+		}
+	}
+	if len(j.ackErrorCounts) > 0 {
+		status.AckErrorCounts = make(map[string]int, len(j.ackErrorCounts))
+		for ackStatus, n := range j.ackErrorCounts {
+			status.AckErrorCounts[ackStatus] = n
+		}
+	}
+	if !j.seekedAt.IsZero() {
+		seekedAt := j.seekedAt
+		status.SeekedAt = &seekedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	return status
+}
+
+// start runs the job in the background
+func (j *Job) start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	j.mu.Lock()
+	j.cancel = cancel
+	j.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		j.run(ctx)
+	}()
+}
+
+// run executes the job until it completes, fails or is cancelled
+func (j *Job) run(ctx context.Context) {
+	ctx, span := j.startJobSpan(ctx)
+	defer j.endJobSpan(span)
+	go j.reportProgress(ctx)
+
+	_, err := processShovelRequest(ctx, j)
+	j.finish(err)
+	status := j.Status()
+	if err != nil {
+		j.log(ctx, slog.LevelError, "Request failed", "state", status.State, "error", err)
+	} else {
+		j.log(ctx, slog.LevelInfo, "Request finished", "state", status.State, "stopReason", status.StopReason)
+	}
+}
+
+// reportProgress periodically logs the job counters and warns when it stalls
+func (j *Job) reportProgress(ctx context.Context) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	lastProcessed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status := j.Status()
+			rate := float64(status.ProcessedCount-lastProcessed) / progressInterval.Seconds()
+			lastProcessed = status.ProcessedCount
+			j.log(ctx, slog.LevelInfo, "Request progress", "messagesPerSecond", rate)
+			if !status.Healthy {
+				j.log(ctx, slog.LevelWarn, "Request is stalled", "lastProgressAt", status.LastProgressAt)
+			}
+			j.persist()
+			j.saveDedupKeys(ctx)
+		}
+	}
+}
+
+// jobRegistry keeps track of the jobs running on this instance. Finished jobs
+// are removed once their final state is stored.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// jobs is the registry used by the HTTP handlers
+var jobs = &jobRegistry{jobs: make(map[string]*Job)}
+
+// add registers a job, making its ID unique if necessary. IDs of stored jobs
+// are taken as well, so that finished jobs keep their record.
+func (r *jobRegistry) add(job *Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := job.ID
+	for i := 2; r.jobs[id] != nil || stored(id); i++ {
+		id = fmt.Sprintf("%s-%d", job.ID, i)
+	}
+	job.ID = id
+	r.jobs[id] = job
+}
+
+// put registers a job under its own ID, e.g. a resumed one
+func (r *jobRegistry) put(job *Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[job.ID] = job
+}
+
+// remove unregisters job
+func (r *jobRegistry) remove(job *Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jobs[job.ID] == job {
+		delete(r.jobs, job.ID)
+	}
+}
+
+// stored reports whether the job store has a record of id
+func stored(id string) bool {
+	_, err := jobStore.Get(context.Background(), id)
+	return err == nil
+}
+
+// get returns the job with the given ID or nil
+func (r *jobRegistry) get(id string) *Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.jobs[id]
+}
+
+// list returns all jobs ordered by start time
+func (r *jobRegistry) list() []*Job {
+	r.mu.Lock()
+	result := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		result = append(result, job)
+	}
+	r.mu.Unlock()
+	sort.Slice(result, func(i, k int) bool {
+		return result[i].startedAt.Before(result[k].startedAt)
+	})
+	return result
+}
+
+// startJob registers a job for the request and runs it in the background.
+// ctx only carries the trace and principal the job belongs to and must not be
+// cancelled.
+func startJob(ctx context.Context, id string, req ShovelRequest) *Job {
+	job := newJob(id, req)
+	job.Principal = PrincipalFromContext(ctx)
+	jobs.add(job)
+	job.persist()
+	job.audit(AuditJobStarted)
+	job.start(ctx)
+	return job
+}
+
+// DrainJobs waits for all running jobs to finish. Continuous jobs never finish
+// on their own and are interrupted right away; any job still running when ctx
+// expires is stopped as described in Shutdown.
+func DrainJobs(ctx context.Context) error {
+	running := runningJobs()
+	for _, job := range running {
+		if job.Request.Mode == ModeContinuous {
+			job.interrupt()
+		}
+	}
+
+	logger.Info("Draining running jobs", "count", len(running))
+	return waitForJobs(ctx, running)
+}
+
+// Shutdown interrupts all running jobs and waits until ctx expires for their
+// outstanding publishes. Messages whose publish did not complete by then are
+// nacked so that they are redelivered promptly instead of waiting for their
+// ack deadline.
+func Shutdown(ctx context.Context) error {
+	running := runningJobs()
+	for _, job := range running {
+		job.interrupt()
+	}
+
+	logger.Info("Shutting down running jobs", "count", len(running))
+	return waitForJobs(ctx, running)
+}
+
+// runningJobs returns the jobs that have not finished yet
+func runningJobs() []*Job {
+	var running []*Job
+	for _, job := range jobs.list() {
+		if job.Status().State == JobStateRunning {
+			running = append(running, job)
+		}
+	}
+	return running
+}
+
+// waitForJobs waits for the given jobs to finish. When ctx expires first, the
+// remaining jobs are interrupted and their outstanding publishes aborted.
+func waitForJobs(ctx context.Context, running []*Job) error {
+	for _, job := range running {
+		select {
+		case <-job.Done():
+		case <-ctx.Done():
+			for _, job := range running {
+				job.interrupt()
+				job.abortPublishes()
+			}
+
+			// Give the aborted jobs a moment to nack and record their final state
+			graceCtx, cancel := context.WithTimeout(context.Background(), abortGracePeriod)
+			defer cancel()
+			for _, job := range running {
+				select {
+				case <-job.Done():
+				case <-graceCtx.Done():
+				}
+			}
+			return fmt.Errorf("jobs did not finish in time: %v", ctx.Err())
+		}
+	}
+	return nil
+}
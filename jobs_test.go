@@ -0,0 +1,231 @@
+package shovel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestJob_TryAccept(t *testing.T) {
+	job := newJob("test", ShovelRequest{})
+	for i := 0; i < 3; i++ {
+		if !job.tryAccept(3) {
+			t.Fatalf("Expected message %d to be accepted", i+1)
+		}
+	}
+	if job.tryAccept(3) {
+		t.Error("Expected message beyond the limit to be rejected")
+	}
+	if !job.tryAccept(0) {
+		t.Error("Expected no limit when max is 0")
+	}
+}
+
+func TestJob_FinishStates(t *testing.T) {
+	tests := []struct {
+		name     string
+		cancel   bool
+		err      error
+		expected JobState
+		reason   string
+	}{
+		{name: "completed", expected: JobStateCompleted, reason: StopReasonCompleted},
+		{name: "cancelled", cancel: true, expected: JobStateCancelled, reason: StopReasonCancelled},
+		{name: "failed", err: errors.New("boom"), expected: JobStateFailed, reason: StopReasonError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newJob("test", ShovelRequest{})
+			if tt.cancel && !job.Cancel() {
+				t.Fatal("Expected running job to be cancellable")
+			}
+			job.finish(tt.err)
+
+			status := job.Status()
+			if status.State != tt.expected {
+				t.Errorf("Expected state %s, got %s", tt.expected, status.State)
+			}
+			if status.StopReason != tt.reason {
+				t.Errorf("Expected stop reason %s, got %s", tt.reason, status.StopReason)
+			}
+			if status.FinishedAt == nil {
+				t.Error("Expected finishedAt to be set")
+			}
+			if job.Cancel() {
+				t.Error("Expected finished job not to be cancellable")
+			}
+		})
+	}
+}
+
+func TestJob_StopReasonAndErrorCounts(t *testing.T) {
+	job := newJob("test", ShovelRequest{})
+	job.setStopReason(StopReasonLimitReached)
+	job.setStopReason(StopReasonTimeout)
+	job.recordFailed(status.Error(codes.PermissionDenied, "denied"))
+	job.recordFailed(status.Error(codes.PermissionDenied, "denied"))
+	job.recordFailed(errors.New("boom"))
+	job.finish(nil)
+
+	s := job.Status()
+	if s.StopReason != StopReasonLimitReached {
+		t.Errorf("Expected first stop reason to win, got %s", s.StopReason)
+	}
+	if s.ErrorCounts["PermissionDenied"] != 2 || s.ErrorCounts["Unknown"] != 1 {
+		t.Errorf("Expected errors broken down by code, got %v", s.ErrorCounts)
+	}
+}
+
+func TestJob_Stalled(t *testing.T) {
+	job := newJob("test", ShovelRequest{})
+	job.tryAccept(0)
+	if !job.Status().Healthy {
+		t.Error("Expected job with recent progress to be healthy")
+	}
+
+	job.lastProgress = time.Now().Add(-2 * stallThreshold)
+	if job.Status().Healthy {
+		t.Error("Expected job with in-flight messages and no progress to be stalled")
+	}
+
+	job.recordProcessed()
+	if !job.Status().Healthy {
+		t.Error("Expected job to recover after progress")
+	}
+}
+
+func TestJobRegistry_UniqueIDs(t *testing.T) {
+	registry := &jobRegistry{jobs: make(map[string]*Job)}
+	first := newJob("shovel-1", ShovelRequest{})
+	second := newJob("shovel-1", ShovelRequest{})
+	registry.add(first)
+	registry.add(second)
+
+	if first.ID == second.ID {
+		t.Errorf("Expected unique job IDs, got %s twice", first.ID)
+	}
+	if registry.get(second.ID) != second {
+		t.Error("Expected second job to be retrievable by its ID")
+	}
+	if len(registry.list()) != 2 {
+		t.Errorf("Expected 2 jobs, got %d", len(registry.list()))
+	}
+}
+
+// failingJobStore fails to save records
+type failingJobStore struct {
+	JobStore
+}
+
+func (failingJobStore) Save(context.Context, JobRecord) error {
+	return errors.New("store unavailable")
+}
+
+func TestJobRegistry_EvictsFinishedJobs(t *testing.T) {
+	original := jobStore
+	store := NewMemoryJobStore()
+	SetJobStore(store)
+	t.Cleanup(func() { SetJobStore(original) })
+
+	job := newJob("shovel-evict-test", ShovelRequest{Dedup: &DedupConfig{}})
+	jobs.add(job)
+	job.dedup.claim("42")
+	job.dedup.commit("42")
+	job.finish(nil)
+
+	if jobs.get(job.ID) != nil {
+		t.Error("Expected the finished job to be removed from the registry")
+	}
+	if record, err := store.Get(context.Background(), job.ID); err != nil || record.Status.State != JobStateCompleted {
+		t.Errorf("Expected the final state in the store, got %v, %v", record.Status.State, err)
+	}
+	if keys := job.dedup.snapshot(); len(keys) != 0 {
+		t.Errorf("Expected the dedup keys to be released, got %v", keys)
+	}
+
+	// Stored IDs stay taken
+	next := newJob("shovel-evict-test", ShovelRequest{})
+	jobs.add(next)
+	defer next.finish(nil)
+	if next.ID == job.ID {
+		t.Errorf("Expected a new ID, got %s again", next.ID)
+	}
+
+	SetJobStore(failingJobStore{store})
+	unsaved := newJob("shovel-unsaved-test", ShovelRequest{})
+	jobs.add(unsaved)
+	unsaved.finish(nil)
+	if jobs.get(unsaved.ID) != unsaved {
+		t.Error("Expected a job whose final state was not stored to stay registered")
+	}
+	SetJobStore(store)
+	jobs.remove(unsaved)
+}
+
+func TestStatusAndCancelHandlers(t *testing.T) {
+	job := newJob("shovel-status-test", ShovelRequest{Mode: ModeContinuous, TargetTopic: "projects/test/topics/target"})
+	jobs.add(job)
+
+	rr := httptest.NewRecorder()
+	StatusHandler(rr, httptest.NewRequest("GET", "/?jobId="+job.ID, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var status JobStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.State != JobStateRunning || status.Mode != ModeContinuous {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	rr = httptest.NewRecorder()
+	CancelHandler(rr, httptest.NewRequest("POST", "/?jobId="+job.ID, nil))
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
+	}
+	job.finish(nil)
+
+	rr = httptest.NewRecorder()
+	CancelHandler(rr, httptest.NewRequest("POST", "/?jobId="+job.ID, nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	StatusHandler(rr, httptest.NewRequest("GET", "/?jobId=unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	job := newJob("shovel-health-test", ShovelRequest{})
+	jobs.add(job)
+	defer job.finish(nil)
+
+	rr := httptest.NewRecorder()
+	HealthHandler(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	job.tryAccept(0)
+	job.mu.Lock()
+	job.lastProgress = time.Now().Add(-2 * stallThreshold)
+	job.mu.Unlock()
+
+	rr = httptest.NewRecorder()
+	HealthHandler(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
@@ -0,0 +1,260 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// kafkaMaxOutstanding bounds the number of records that are published but not yet committed
+const kafkaMaxOutstanding = 100
+
+// kafkaIdleTimeout ends an allMessages run once no new record arrived for this long
+const kafkaIdleTimeout = 30 * time.Second
+
+// KafkaSource describes a Kafka topic consumed through a consumer group
+type KafkaSource struct {
+	Brokers []string `json:"brokers"` // Bootstrap broker addresses (host:port)
+	Topic   string   `json:"topic"`   // Kafka topic to consume from
+	GroupID string   `json:"groupId"` // Consumer group used for offset commits
+}
+
+// kafkaReader is the subset of *kafka.Reader used by the shovel
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// newKafkaReader creates a consumer group reader for the given source
+var newKafkaReader = func(src *KafkaSource) kafkaReader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers: src.Brokers,
+		Topic:   src.Topic,
+		GroupID: src.GroupID,
+	})
+}
+
+// kafkaPublish pairs a fetched record with its pending publish result
+type kafkaPublish struct {
+	record      kafka.Message
+	result      *pubsub.PublishResult
+	publishedAt time.Time
+	ctx         context.Context // Carries the message span
+	span        trace.Span      // Message span
+	publishSpan trace.Span
+}
+
+// validateKafkaSource validates the Kafka source configuration
+func validateKafkaSource(src *KafkaSource) error {
+	if len(src.Brokers) == 0 {
+		return fmt.Errorf("sourceKafka.brokers is required")
+	}
+	if src.Topic == "" {
+		return fmt.Errorf("sourceKafka.topic is required")
+	}
+	if src.GroupID == "" {
+		return fmt.Errorf("sourceKafka.groupId is required")
+	}
+	return nil
+}
+
+// processKafkaRequest shovels records from a Kafka topic into the target topic
+func processKafkaRequest(ctx context.Context, job *Job) (int, error) {
+	req := &job.Request
+
+	target, err := ParseTopic(req.TargetTopic)
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := pubsubClient(ctx, target.Project, req.TargetServiceAccount)
+	if err != nil {
+		return 0, err
+	}
+	targetTopic := client.TopicInProject(target.ID, target.Project)
+	exists, err := targetTopic.Exists(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check if target topic exists: %v", err)
+	}
+	if !exists {
+		return 0, fmt.Errorf("target topic %s does not exist", req.TargetTopic)
+	}
+	// Record keys become ordering keys, which requires ordered publishing
+	targetTopic.EnableMessageOrdering = true
+	targetTopic.PublishSettings.FlowControlSettings = pubsub.FlowControlSettings{
+		MaxOutstandingMessages: 1000,
+		MaxOutstandingBytes:    100 * 1024 * 1024,
+		LimitExceededBehavior:  pubsub.FlowControlBlock,
+	}
+	defer job.stopTopic(targetTopic)
+
+	reader := newKafkaReader(req.SourceKafka)
+	defer func() {
+		if err := reader.Close(); err != nil {
+			logger.Warn("Failed to close kafka reader", "error", err)
+		}
+	}()
+
+	// Continuous jobs have no limit, timeout or idle cutoff
+	var maxMessages int
+	var timeout, idleTimeout time.Duration
+	switch {
+	case req.Mode == ModeContinuous:
+	case req.AllMessages:
+		maxMessages = 10000
+		timeout = allMessagesTimeout
+		idleTimeout = kafkaIdleTimeout
+	default:
+		maxMessages = req.NumMessages
+		timeout = oneShotTimeout
+		idleTimeout = kafkaIdleTimeout
+	}
+
+	return shovelFromKafka(ctx, reader, targetTopic, job, maxMessages, timeout, idleTimeout)
+}
+
+// shovelFromKafka publishes up to maxMessages records to targetTopic, or
+// forwards until ctx is cancelled when maxMessages is 0. Offsets
+// are committed strictly in fetch order and only after the publish of that
+// record (and every record before it) succeeded, so a failure leaves the
+// remaining records uncommitted for the next run of the consumer group.
+func shovelFromKafka(ctx context.Context, reader kafkaReader, targetTopic *pubsub.Topic, job *Job, maxMessages int, timeout, idleTimeout time.Duration) (int, error) {
+	var fetchCtx context.Context
+	var cancelFetch context.CancelFunc
+	if timeout > 0 {
+		fetchCtx, cancelFetch = context.WithTimeout(ctx, timeout)
+	} else {
+		fetchCtx, cancelFetch = context.WithCancel(ctx)
+	}
+	defer cancelFetch()
+
+	pending := make(chan kafkaPublish, kafkaMaxOutstanding)
+	done := make(chan bool)
+	var commitErr error
+	var aborted bool
+
+	// Wait for publish results and commit offsets in order. This outlives ctx
+	// so that records published before a cancellation are still committed.
+	publishCtx := job.publishContext()
+	go func() {
+		for p := range pending {
+			if commitErr != nil {
+				// Drain remaining results without committing past the failure
+				p.publishSpan.End()
+				p.span.End()
+				continue
+			}
+			_, err := p.result.Get(publishCtx)
+			if err == nil && aborted {
+				err = publishCtx.Err()
+			}
+			endSpan(p.publishSpan, err)
+			if err != nil && publishCtx.Err() != nil {
+				// Publish aborted during shutdown. The record and every record
+				// after it stay uncommitted and are fetched again on resume.
+				aborted = true
+				job.recordNacked()
+				endSpan(p.span, err)
+				job.logMessage(p.ctx, "Left record uncommitted after aborted publish", fmt.Sprintf("%s/%d@%d", p.record.Topic, p.record.Partition, p.record.Offset))
+				cancelFetch()
+				continue
+			}
+			if err != nil {
+				commitErr = fmt.Errorf("failed to publish record %s/%d@%d: %v", p.record.Topic, p.record.Partition, p.record.Offset, err)
+				job.recordFailed(err)
+				endSpan(p.span, err)
+				cancelFetch()
+				continue
+			}
+			job.metrics.publishedMessage(time.Since(p.publishedAt))
+			err = traceStage(p.ctx, "shovel.ack", func() error {
+				return reader.CommitMessages(publishCtx, p.record)
+			})
+			endSpan(p.span, err)
+			if err != nil {
+				commitErr = fmt.Errorf("failed to commit record %s/%d@%d: %v", p.record.Topic, p.record.Partition, p.record.Offset, err)
+				cancelFetch()
+				continue
+			}
+			job.recordProcessed()
+			job.auditMessage(fmt.Sprintf("%s/%d@%d", p.record.Topic, p.record.Partition, p.record.Offset))
+		}
+		done <- true
+	}()
+
+	var fetchErr error
+	for maxMessages == 0 || job.acceptedCount() < maxMessages {
+		recordCtx, cancelRecord := fetchCtx, context.CancelFunc(func() {})
+		if idleTimeout > 0 {
+			recordCtx, cancelRecord = context.WithTimeout(fetchCtx, idleTimeout)
+		}
+		record, err := reader.FetchMessage(recordCtx)
+		cancelRecord()
+		if err != nil {
+			switch {
+			case fetchCtx.Err() != nil:
+				job.log(ctx, slog.LevelInfo, "Kafka fetch stopped", "reason", fetchCtx.Err())
+				if ctx.Err() == nil && errors.Is(fetchCtx.Err(), context.DeadlineExceeded) {
+					job.setStopReason(StopReasonTimeout)
+				}
+			case errors.Is(err, context.DeadlineExceeded):
+				job.log(ctx, slog.LevelInfo, "No new Kafka records", "idleTimeout", idleTimeout)
+				job.setStopReason(StopReasonSourceIdle)
+			default:
+				fetchErr = fmt.Errorf("failed to fetch kafka record: %v", err)
+			}
+			break
+		}
+		job.metrics.receivedMessage(len(record.Value))
+		job.recordAccepted()
+		msg := kafkaRecordToMessage(record)
+		recordID := fmt.Sprintf("%s/%d@%d", record.Topic, record.Partition, record.Offset)
+		msgCtx, msgSpan := job.startMessageSpan(ctx, recordID, len(record.Value), msg.Attributes)
+		job.logMessage(msgCtx, "Fetched record", recordID, "size", len(record.Value))
+		msg.Attributes = job.outgoingAttributes(msgCtx, msg.Attributes)
+		publishSpan := startPublishSpan(msgCtx)
+		pending <- kafkaPublish{
+			record:      record,
+			result:      targetTopic.Publish(ctx, msg),
+			publishedAt: time.Now(),
+			ctx:         msgCtx,
+			span:        msgSpan,
+			publishSpan: publishSpan,
+		}
+	}
+	if maxMessages > 0 && job.acceptedCount() >= maxMessages {
+		job.setStopReason(StopReasonLimitReached)
+	}
+	close(pending)
+	<-done
+
+	status := job.Status()
+	job.log(ctx, slog.LevelInfo, "Kafka shovel completed")
+	if commitErr != nil {
+		return status.ProcessedCount, commitErr
+	}
+	return status.ProcessedCount, fetchErr
+}
+
+// kafkaRecordToMessage maps a Kafka record onto a Pub/Sub message, turning
+// headers into attributes and the record key into the ordering key
+func kafkaRecordToMessage(record kafka.Message) *pubsub.Message {
+	msg := &pubsub.Message{
+		Data:        record.Value,
+		OrderingKey: string(record.Key),
+	}
+	if len(record.Headers) > 0 {
+		msg.Attributes = make(map[string]string, len(record.Headers))
+		for _, h := range record.Headers {
+			msg.Attributes[h.Key] = string(h.Value)
+		}
+	}
+	return msg
+}
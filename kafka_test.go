@@ -0,0 +1,239 @@
+package shovel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeKafkaReader serves a fixed list of records and records commits
+type fakeKafkaReader struct {
+	mu        sync.Mutex
+	records   []kafka.Message
+	next      int
+	committed []int64
+}
+
+func (r *fakeKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if r.next < len(r.records) {
+		record := r.records[r.next]
+		r.next++
+		r.mu.Unlock()
+		return record, nil
+	}
+	r.mu.Unlock()
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeKafkaReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+	return nil
+}
+
+func (r *fakeKafkaReader) Close() error { return nil }
+
+// failingPublishReactor rejects every publish call
+type failingPublishReactor struct{}
+
+func (failingPublishReactor) React(_ interface{}) (bool, interface{}, error) {
+	return true, nil, status.Error(codes.PermissionDenied, "publish denied")
+}
+
+// hangingPublishReactor holds every publish call until release is closed
+type hangingPublishReactor struct {
+	release chan struct{}
+}
+
+func (r hangingPublishReactor) React(_ interface{}) (bool, interface{}, error) {
+	<-r.release
+	return false, nil, nil
+}
+
+// newTestClient starts a fake Pub/Sub server and returns a client for project "test"
+func newTestClient(t *testing.T, opts ...pstest.ServerReactorOption) (*pstest.Server, *pubsub.Client) {
+	t.Helper()
+	srv := pstest.NewServer(opts...)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := grpc.Dial(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial fake server: %v", err)
+	}
+	client, err := pubsub.NewClient(context.Background(), "test", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return srv, client
+}
+
+// newTestTopic starts a fake Pub/Sub server and creates topicID in project "test"
+func newTestTopic(t *testing.T, topicID string, opts ...pstest.ServerReactorOption) (*pstest.Server, *pubsub.Topic) {
+	t.Helper()
+	srv, client := newTestClient(t, opts...)
+	topic, err := client.CreateTopic(context.Background(), topicID)
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	topic.EnableMessageOrdering = true
+	t.Cleanup(topic.Stop)
+	return srv, topic
+}
+
+func TestShovelFromKafka(t *testing.T) {
+	srv, topic := newTestTopic(t, "target")
+	reader := &fakeKafkaReader{records: []kafka.Message{
+		{Topic: "orders", Offset: 0, Key: []byte("customer-1"), Value: []byte("a"), Headers: []kafka.Header{{Key: "type", Value: []byte("created")}}},
+		{Topic: "orders", Offset: 1, Key: []byte("customer-1"), Value: []byte("b")},
+		{Topic: "orders", Offset: 2, Value: []byte("c")},
+	}}
+
+	processed, err := shovelFromKafka(context.Background(), reader, topic, newJob("test", ShovelRequest{}), 10, time.Minute, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if processed != 3 {
+		t.Errorf("Expected 3 processed records, got %d", processed)
+	}
+	if len(reader.committed) != 3 || reader.committed[2] != 2 {
+		t.Errorf("Expected offsets 0-2 to be committed in order, got %v", reader.committed)
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 published messages, got %d", len(msgs))
+	}
+	byData := map[string]*pstest.Message{}
+	for _, m := range msgs {
+		byData[string(m.Data)] = m
+	}
+	if m := byData["a"]; m.OrderingKey != "customer-1" || m.Attributes["type"] != "created" {
+		t.Errorf("Expected ordering key and attributes to be mapped, got %q %v", m.OrderingKey, m.Attributes)
+	}
+	if m := byData["c"]; m.OrderingKey != "" {
+		t.Errorf("Expected empty ordering key for record without key, got %q", m.OrderingKey)
+	}
+}
+
+func TestShovelFromKafka_RespectsLimit(t *testing.T) {
+	_, topic := newTestTopic(t, "target")
+	reader := &fakeKafkaReader{records: []kafka.Message{
+		{Offset: 0, Value: []byte("a")},
+		{Offset: 1, Value: []byte("b")},
+		{Offset: 2, Value: []byte("c")},
+	}}
+
+	processed, err := shovelFromKafka(context.Background(), reader, topic, newJob("test", ShovelRequest{}), 2, time.Minute, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if processed != 2 || len(reader.committed) != 2 {
+		t.Errorf("Expected 2 processed and committed records, got %d and %v", processed, reader.committed)
+	}
+}
+
+func TestShovelFromKafka_PublishFailureDoesNotCommit(t *testing.T) {
+	_, topic := newTestTopic(t, "target", pstest.ServerReactorOption{
+		FuncName: "Publish",
+		Reactor:  failingPublishReactor{},
+	})
+	reader := &fakeKafkaReader{records: []kafka.Message{
+		{Offset: 0, Value: []byte("a")},
+	}}
+
+	processed, err := shovelFromKafka(context.Background(), reader, topic, newJob("test", ShovelRequest{}), 10, time.Minute, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("Expected publish failure to be reported")
+	}
+	if processed != 0 || len(reader.committed) != 0 {
+		t.Errorf("Expected nothing to be committed, got %d processed and %v committed", processed, reader.committed)
+	}
+}
+
+func TestValidateKafkaSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     KafkaSource
+		wantErr bool
+	}{
+		{name: "valid", src: KafkaSource{Brokers: []string{"localhost:9092"}, Topic: "orders", GroupID: "shovel"}},
+		{name: "missing brokers", src: KafkaSource{Topic: "orders", GroupID: "shovel"}, wantErr: true},
+		{name: "missing topic", src: KafkaSource{Brokers: []string{"localhost:9092"}, GroupID: "shovel"}, wantErr: true},
+		{name: "missing group", src: KafkaSource{Brokers: []string{"localhost:9092"}, Topic: "orders"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKafkaSource(&tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestShovelFromKafka_ContinuousRunsUntilCancelled(t *testing.T) {
+	_, topic := newTestTopic(t, "target")
+	reader := &fakeKafkaReader{records: []kafka.Message{
+		{Offset: 0, Value: []byte("a")},
+		{Offset: 1, Value: []byte("b")},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	processed, err := shovelFromKafka(ctx, reader, topic, newJob("test", ShovelRequest{Mode: ModeContinuous}), 0, 0, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if processed != 2 {
+		t.Errorf("Expected 2 processed records, got %d", processed)
+	}
+}
+
+func TestShovelFromKafka_AbortedPublishLeavesRecordUncommitted(t *testing.T) {
+	release := make(chan struct{})
+	_, topic := newTestTopic(t, "target", pstest.ServerReactorOption{
+		FuncName: "Publish",
+		Reactor:  hangingPublishReactor{release: release},
+	})
+	t.Cleanup(func() { close(release) })
+	reader := &fakeKafkaReader{records: []kafka.Message{
+		{Offset: 0, Value: []byte("a")},
+	}}
+
+	// Shutdown cancels the job and then aborts its outstanding publishes
+	job := newJob("test", ShovelRequest{Mode: ModeContinuous})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, func() {
+		cancel()
+		job.abortPublishes()
+	})
+
+	processed, err := shovelFromKafka(ctx, reader, topic, job, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if processed != 0 || len(reader.committed) != 0 {
+		t.Errorf("Expected nothing to be committed, got %d processed and %v committed", processed, reader.committed)
+	}
+	if status := job.Status(); status.NackedCount != 1 || status.FailedCount != 0 {
+		t.Errorf("Expected the record to be counted as nacked, got %d nacked and %d failed", status.NackedCount, status.FailedCount)
+	}
+}
@@ -0,0 +1,167 @@
+package shovel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page size limits of the list endpoint
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// JobFilter selects jobs by state, resources and start time. Zero fields match everything.
+type JobFilter struct {
+	State              JobState
+	SourceSubscription string
+	TargetTopic        string
+	StartedAfter       time.Time
+	StartedBefore      time.Time
+}
+
+// Matches reports whether record satisfies the filter
+func (f JobFilter) Matches(record JobRecord) bool {
+	status := record.Status
+	if f.State != "" && status.State != f.State {
+		return false
+	}
+	if f.SourceSubscription != "" && status.SourceSubscription != f.SourceSubscription {
+		return false
+	}
+	if f.TargetTopic != "" && status.TargetTopic != f.TargetTopic {
+		return false
+	}
+	if !f.StartedAfter.IsZero() && status.StartedAt.Before(f.StartedAfter) {
+		return false
+	}
+	if !f.StartedBefore.IsZero() && !status.StartedAt.Before(f.StartedBefore) {
+		return false
+	}
+	return true
+}
+
+// JobSummary is a job status with derived duration and throughput
+type JobSummary struct {
+	JobStatus
+	DurationSeconds float64 `json:"durationSeconds"`
+	Throughput      float64 `json:"throughput"` // Processed messages per second
+}
+
+// JobList is a page of job summaries
+type JobList struct {
+	Jobs          []JobSummary `json:"jobs"`
+	TotalCount    int          `json:"totalCount"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+}
+
+// summarize derives duration and throughput from a status
+func summarize(status JobStatus, now time.Time) JobSummary {
+	end := now
+	if status.FinishedAt != nil {
+		end = *status.FinishedAt
+	}
+	duration := end.Sub(status.StartedAt).Seconds()
+	summary := JobSummary{JobStatus: status, DurationSeconds: duration}
+	if duration > 0 {
+		summary.Throughput = float64(status.ProcessedCount) / duration
+	}
+	return summary
+}
+
+// parseJobFilter reads the filter from the query parameters state,
+// sourceSubscription, targetTopic, startedAfter and startedBefore
+func parseJobFilter(r *http.Request) (JobFilter, error) {
+	q := r.URL.Query()
+	filter := JobFilter{
+		State:              JobState(q.Get("state")),
+		SourceSubscription: q.Get("sourceSubscription"),
+		TargetTopic:        q.Get("targetTopic"),
+	}
+	for name, target := range map[string]*time.Time{
+		"startedAfter":  &filter.StartedAfter,
+		"startedBefore": &filter.StartedBefore,
+	} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*target = t
+		}
+	}
+	return filter, nil
+}
+
+// parsePage reads pageSize and pageToken. The token is the offset of the
+// first job on the page.
+func parsePage(r *http.Request) (int, int, error) {
+	q := r.URL.Query()
+	pageSize := defaultPageSize
+	if v := q.Get("pageSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return 0, 0, fmt.Errorf("pageSize must be between 1 and %d", maxPageSize)
+		}
+		pageSize = n
+	}
+	offset := 0
+	if v := q.Get("pageToken"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid pageToken")
+		}
+		offset = n
+	}
+	return pageSize, offset, nil
+}
+
+// ListHandler lists the jobs in the job store matching the query filters
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if handleCORS(w, r, "GET") {
+		return
+	}
+	r, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := parseJobFilter(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageSize, offset, err := parsePage(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := jobStore.List(r.Context(), filter)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Failed to list jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	list := JobList{Jobs: []JobSummary{}, TotalCount: len(records)}
+	now := time.Now()
+	for i := offset; i < len(records) && i < offset+pageSize; i++ {
+		status := records[i].Status
+		// Prefer live counters for jobs running on this instance
+		if job := jobs.get(records[i].ID); job != nil {
+			status = job.Status()
+		}
+		list.Jobs = append(list.Jobs, summarize(status, now))
+	}
+	if offset+pageSize < len(records) {
+		list.NextPageToken = strconv.Itoa(offset + pageSize)
+	}
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		logger.Error("Failed to encode response", "error", err)
+	}
+}
@@ -0,0 +1,124 @@
+package shovel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// seedJobStore replaces the job store with one holding n finished jobs that
+// started a minute apart, alternating between two target topics
+func seedJobStore(t *testing.T, n int) time.Time {
+	t.Helper()
+	original := jobStore
+	store := NewMemoryJobStore()
+	SetJobStore(store)
+	t.Cleanup(func() { SetJobStore(original) })
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		job := newJob(fmt.Sprintf("shovel-list-%d", i), ShovelRequest{
+			SourceSubscription: "projects/test/subscriptions/source",
+			TargetTopic:        fmt.Sprintf("projects/test/topics/target-%d", i%2),
+		})
+		job.startedAt = base.Add(time.Duration(i) * time.Minute)
+		for k := 0; k < 10; k++ {
+			job.tryAccept(0)
+			job.recordProcessed()
+		}
+		job.finish(nil)
+		job.finishedAt = job.startedAt.Add(5 * time.Second)
+		store.Save(context.Background(), job.Record())
+	}
+	return base
+}
+
+func listJobs(t *testing.T, query string) (int, JobList) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	ListHandler(rr, httptest.NewRequest("GET", "/jobs?"+query, nil))
+	var list JobList
+	if rr.Code == http.StatusOK {
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode list: %v", err)
+		}
+	}
+	return rr.Code, list
+}
+
+func TestListHandler_Filters(t *testing.T) {
+	base := seedJobStore(t, 6)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{name: "no filter", query: "", expected: 6},
+		{name: "state", query: "state=completed", expected: 6},
+		{name: "other state", query: "state=running", expected: 0},
+		{name: "target topic", query: "targetTopic=projects/test/topics/target-1", expected: 3},
+		{name: "source subscription", query: "sourceSubscription=projects/test/subscriptions/other", expected: 0},
+		{name: "started after", query: "startedAfter=" + base.Add(2*time.Minute).Format(time.RFC3339), expected: 4},
+		{name: "time range", query: "startedAfter=" + base.Add(time.Minute).Format(time.RFC3339) + "&startedBefore=" + base.Add(3*time.Minute).Format(time.RFC3339), expected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, list := listJobs(t, tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+			}
+			if list.TotalCount != tt.expected || len(list.Jobs) != tt.expected {
+				t.Errorf("Expected %d jobs, got %d (total %d)", tt.expected, len(list.Jobs), list.TotalCount)
+			}
+		})
+	}
+}
+
+func TestListHandler_Pagination(t *testing.T) {
+	seedJobStore(t, 5)
+
+	var ids []string
+	token := ""
+	for page := 0; page < 5; page++ {
+		_, list := listJobs(t, "pageSize=2&pageToken="+token)
+		for _, job := range list.Jobs {
+			ids = append(ids, job.ID)
+		}
+		token = list.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+	if len(ids) != 5 || ids[0] != "shovel-list-0" || ids[4] != "shovel-list-4" {
+		t.Errorf("Expected all 5 jobs in start order across pages, got %v", ids)
+	}
+}
+
+func TestListHandler_Summary(t *testing.T) {
+	seedJobStore(t, 1)
+
+	_, list := listJobs(t, "")
+	if len(list.Jobs) != 1 {
+		t.Fatalf("Expected 1 job, got %d", len(list.Jobs))
+	}
+	summary := list.Jobs[0]
+	if summary.DurationSeconds != 5 || summary.Throughput != 2 {
+		t.Errorf("Expected 5s duration and 2 msg/s, got %v and %v", summary.DurationSeconds, summary.Throughput)
+	}
+}
+
+func TestListHandler_InvalidParameters(t *testing.T) {
+	seedJobStore(t, 1)
+
+	for _, query := range []string{"pageSize=0", "pageSize=1000", "pageToken=abc", "startedAfter=yesterday"} {
+		if code, _ := listJobs(t, query); code != http.StatusBadRequest {
+			t.Errorf("Expected %q to be rejected, got status code %d", query, code)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package shovel
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logger is used for all log output of the shovel. It is also installed as
+// the slog default so that log.Printf output of dependencies is structured.
+var logger = NewLogger(os.Stderr, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+
+func init() {
+	slog.SetDefault(logger)
+}
+
+// SetLogger replaces the logger used by the shovel and the slog default
+func SetLogger(l *slog.Logger) {
+	logger = l
+	slog.SetDefault(l)
+}
+
+// NewLogger creates a logger writing to w. The default format is JSON that
+// Cloud Logging understands (severity, message, trace correlation); format
+// "text" writes plain key=value lines instead. level is debug, info (the
+// default), warn or error. Per-message events are logged at debug level.
+func NewLogger(w io.Writer, format, level string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLogLevel(level)}
+	if strings.EqualFold(format, "text") {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	opts.ReplaceAttr = cloudLoggingAttr
+	return slog.New(traceHandler{Handler: slog.NewJSONHandler(w, opts), project: os.Getenv("GOOGLE_CLOUD_PROJECT")})
+}
+
+// parseLogLevel maps a LOG_LEVEL value to a slog level, defaulting to info
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// cloudLoggingAttr renames the top-level slog keys to the ones of the Cloud
+// Logging structured log format
+func cloudLoggingAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.MessageKey:
+		a.Key = "message"
+	case slog.LevelKey:
+		a.Key = "severity"
+		if level, ok := a.Value.Any().(slog.Level); ok && level == slog.LevelWarn {
+			a.Value = slog.StringValue("WARNING")
+		}
+	}
+	return a
+}
+
+// traceHandler adds the Cloud Logging trace fields when the logging context
+// carries a span, so that log lines show up next to the job's trace
+type traceHandler struct {
+	slog.Handler
+	project string
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		traceID := sc.TraceID().String()
+		if h.project != "" {
+			traceID = "projects/" + h.project + "/traces/" + traceID
+		}
+		r.AddAttrs(
+			slog.String("logging.googleapis.com/trace", traceID),
+			slog.String("logging.googleapis.com/spanId", sc.SpanID().String()),
+			slog.Bool("logging.googleapis.com/trace_sampled", sc.IsSampled()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithAttrs(attrs), project: h.project}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithGroup(name), project: h.project}
+}
+
+// logAttrs returns the identity, principal and counters of j for log lines
+func (j *Job) logAttrs() []any {
+	status := j.Status()
+	return []any{
+		slog.String("jobId", j.ID),
+		slog.String("principal", j.Principal.String()),
+		slog.String("source", metricsSource(j.Request)),
+		slog.String("target", j.Request.TargetTopic),
+		slog.Group("counters",
+			slog.Int("accepted", status.AcceptedCount),
+			slog.Int("processed", status.ProcessedCount),
+			slog.Int("failed", status.FailedCount),
+			slog.Int("nacked", status.NackedCount),
+			slog.Int("duplicates", status.DuplicateCount),
+			slog.Int("ackFailed", status.AckFailedCount),
+			slog.Int("inFlight", status.InFlight),
+		),
+	}
+}
+
+// log writes a job event at level, including the job's identity and counters
+func (j *Job) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, msg, append(j.logAttrs(), args...)...)
+}
+
+// logMessage writes a per-message debug event. Counters are left out to keep
+// the hot path cheap.
+func (j *Job) logMessage(ctx context.Context, msg, messageID string, args ...any) {
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	logger.DebugContext(ctx, msg, append([]any{slog.String("jobId", j.ID), slog.String("messageId", messageID)}, args...)...)
+}
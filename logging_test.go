@@ -0,0 +1,111 @@
+package shovel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// captureLogs routes the shovel logs into a buffer for the rest of the test
+func captureLogs(t *testing.T, level string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	original := logger
+	SetLogger(NewLogger(&buf, "json", level))
+	t.Cleanup(func() { SetLogger(original) })
+	return &buf
+}
+
+// logEntries decodes one JSON object per logged line
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestNewLogger_CloudLoggingFormat(t *testing.T) {
+	buf := captureLogs(t, "")
+	logger.Debug("hidden")
+	logger.Info("started")
+	logger.Warn("slow")
+
+	entries := logEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("Expected debug to be filtered at the default level, got %d entries", len(entries))
+	}
+	if entries[0]["severity"] != "INFO" || entries[0]["message"] != "started" {
+		t.Errorf("Expected severity and message keys, got %v", entries[0])
+	}
+	if entries[1]["severity"] != "WARNING" {
+		t.Errorf("Expected WARN to be reported as WARNING, got %v", entries[1]["severity"])
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		level    string
+		expected slog.Level
+	}{
+		{level: "", expected: slog.LevelInfo},
+		{level: "DEBUG", expected: slog.LevelDebug},
+		{level: "warning", expected: slog.LevelWarn},
+		{level: "error", expected: slog.LevelError},
+		{level: "verbose", expected: slog.LevelInfo},
+	}
+
+	for _, tt := range tests {
+		if level := parseLogLevel(tt.level); level != tt.expected {
+			t.Errorf("Expected %q to map to %s, got %s", tt.level, tt.expected, level)
+		}
+	}
+}
+
+func TestJobLog_IncludesIdentityCountersAndTrace(t *testing.T) {
+	buf := captureLogs(t, "debug")
+	job := newJob("shovel-log-test", ShovelRequest{
+		SourceSubscription: "projects/test/subscriptions/source",
+		TargetTopic:        "projects/test/topics/target",
+	})
+	job.tryAccept(0)
+	job.recordProcessed()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	job.log(ctx, slog.LevelInfo, "Request progress")
+	job.logMessage(ctx, "Processed message", "42")
+
+	entries := logEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry["jobId"] != job.ID || entry["source"] != "projects/test/subscriptions/source" || entry["target"] != "projects/test/topics/target" {
+		t.Errorf("Expected job identity, got %v", entry)
+	}
+	counters, _ := entry["counters"].(map[string]interface{})
+	if counters["processed"] != float64(1) {
+		t.Errorf("Expected counters, got %v", entry["counters"])
+	}
+	if !strings.HasSuffix(entry["logging.googleapis.com/trace"].(string), "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("Expected trace correlation, got %v", entry["logging.googleapis.com/trace"])
+	}
+	if entries[1]["messageId"] != "42" || entries[1]["severity"] != "DEBUG" {
+		t.Errorf("Expected per-message debug entry, got %v", entries[1])
+	}
+}
@@ -0,0 +1,133 @@
+package shovel
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds all metrics exposed by MetricsHandler
+var metricsRegistry = prometheus.NewRegistry()
+
+// Message counters are labelled by source and target only. Per-job values are
+// exposed through the in-flight gauge, which disappears once a job finishes.
+var (
+	messagesReceived  = newMessageCounter("shovel_messages_received_total", "Messages received from the source.")
+	messagesPublished = newMessageCounter("shovel_messages_published_total", "Messages published to the target topic.")
+	messagesAcked     = newMessageCounter("shovel_messages_acked_total", "Source messages acknowledged (or committed) after their publish succeeded.")
+	messagesNacked    = newMessageCounter("shovel_messages_nacked_total", "Source messages handed back for redelivery.")
+	messagesFailed    = newMessageCounter("shovel_messages_failed_total", "Messages whose publish failed.")
+	messagesFiltered  = newMessageCounter("shovel_messages_filtered_total", "Messages dropped by a filter stage instead of being published.")
+
+	publishLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shovel_publish_latency_seconds",
+		Help:    "Time from handing a message to the publisher until its publish completed.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"source", "target"})
+	messageSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shovel_message_size_bytes",
+		Help:    "Size of received message payloads.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"source", "target"})
+
+	auditRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shovel_audit_records_total",
+		Help: "Audit records by result: written, failed or dropped because the queue was full.",
+	}, []string{"result"})
+
+	inFlightDesc = prometheus.NewDesc("shovel_messages_in_flight",
+		"Messages accepted by a running job whose publish has not completed yet.", []string{"job_id", "source", "target"}, nil)
+	activeJobsDesc = prometheus.NewDesc("shovel_active_jobs",
+		"Jobs currently running on this instance.", []string{"mode"}, nil)
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		messagesReceived, messagesPublished, messagesAcked, messagesNacked, messagesFailed, messagesFiltered,
+		publishLatency, messageSize, auditRecords,
+		jobCollector{},
+	)
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// newMessageCounter creates a message counter labelled by source and target
+func newMessageCounter(name, help string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, []string{"source", "target"})
+}
+
+// jobMetrics holds the metrics of one job with its labels already applied
+type jobMetrics struct {
+	received       prometheus.Counter
+	published      prometheus.Counter
+	acked          prometheus.Counter
+	nacked         prometheus.Counter
+	failed         prometheus.Counter
+	filtered       prometheus.Counter
+	publishLatency prometheus.Observer
+	messageSize    prometheus.Observer
+}
+
+// newJobMetrics binds the message metrics to the source and target of req
+func newJobMetrics(req ShovelRequest) *jobMetrics {
+	labels := prometheus.Labels{"source": metricsSource(req), "target": req.TargetTopic}
+	return &jobMetrics{
+		received:       messagesReceived.With(labels),
+		published:      messagesPublished.With(labels),
+		acked:          messagesAcked.With(labels),
+		nacked:         messagesNacked.With(labels),
+		failed:         messagesFailed.With(labels),
+		filtered:       messagesFiltered.With(labels),
+		publishLatency: publishLatency.With(labels),
+		messageSize:    messageSize.With(labels),
+	}
+}
+
+// receivedMessage records a message of size bytes delivered by the source
+func (m *jobMetrics) receivedMessage(size int) {
+	m.received.Inc()
+	m.messageSize.Observe(float64(size))
+}
+
+// publishedMessage records a successful publish that took latency
+func (m *jobMetrics) publishedMessage(latency time.Duration) {
+	m.published.Inc()
+	m.publishLatency.Observe(latency.Seconds())
+}
+
+// metricsSource returns the source label of req
+func metricsSource(req ShovelRequest) string {
+	if req.SourceKafka != nil {
+		return "kafka:" + req.SourceKafka.Topic
+	}
+	return req.SourceSubscription
+}
+
+// jobCollector reports gauges for the running jobs when metrics are scraped
+type jobCollector struct{}
+
+func (jobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- inFlightDesc
+	ch <- activeJobsDesc
+}
+
+func (jobCollector) Collect(ch chan<- prometheus.Metric) {
+	active := map[string]int{ModeOneShot: 0, ModeContinuous: 0}
+	for _, job := range runningJobs() {
+		status := job.Status()
+		active[status.Mode]++
+		ch <- prometheus.MustNewConstMetric(inFlightDesc, prometheus.GaugeValue, float64(status.InFlight),
+			status.ID, metricsSource(job.Request), status.TargetTopic)
+	}
+	for mode, n := range active {
+		ch <- prometheus.MustNewConstMetric(activeJobsDesc, prometheus.GaugeValue, float64(n), mode)
+	}
+}
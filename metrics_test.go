@@ -0,0 +1,58 @@
+package shovel
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJobMetrics(t *testing.T) {
+	srv := newShovelFixture(t, 3)
+	useTestServer(t, srv)
+
+	req := ShovelRequest{
+		Mode:               ModeContinuous,
+		SourceSubscription: "projects/test/subscriptions/source-sub",
+		TargetTopic:        "projects/test/topics/target",
+	}
+	labels := []string{req.SourceSubscription, req.TargetTopic}
+	received := testutil.ToFloat64(messagesReceived.WithLabelValues(labels...))
+	published := testutil.ToFloat64(messagesPublished.WithLabelValues(labels...))
+	acked := testutil.ToFloat64(messagesAcked.WithLabelValues(labels...))
+
+	job := newJob("shovel-metrics-test", req)
+	jobs.add(job)
+	job.start(context.Background())
+	waitFor(t, "messages to be processed", func() bool { return job.Status().ProcessedCount == 3 })
+
+	rr := httptest.NewRecorder()
+	MetricsHandler(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rr.Body)
+	for _, expected := range []string{
+		`shovel_messages_in_flight{job_id="shovel-metrics-test"`,
+		`shovel_active_jobs{mode="continuous"}`,
+		`shovel_publish_latency_seconds_count{source="projects/test/subscriptions/source-sub"`,
+		`shovel_message_size_bytes_count{source="projects/test/subscriptions/source-sub"`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to contain %s", expected)
+		}
+	}
+
+	job.Cancel()
+	<-job.Done()
+
+	if n := testutil.ToFloat64(messagesReceived.WithLabelValues(labels...)) - received; n != 3 {
+		t.Errorf("Expected 3 received messages, got %v", n)
+	}
+	if n := testutil.ToFloat64(messagesPublished.WithLabelValues(labels...)) - published; n != 3 {
+		t.Errorf("Expected 3 published messages, got %v", n)
+	}
+	if n := testutil.ToFloat64(messagesAcked.WithLabelValues(labels...)) - acked; n != 3 {
+		t.Errorf("Expected 3 acked messages, got %v", n)
+	}
+}
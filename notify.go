@@ -0,0 +1,210 @@
+package shovel
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// notifyTimeout bounds the delivery of a single notification, including retries
+const notifyTimeout = 30 * time.Second
+
+// notifyAttempts is how often a callback is tried before giving up
+const notifyAttempts = 3
+
+// SignatureHeader carries the HMAC signature of callback bodies. Notifications
+// published to a topic carry the same value in the "signature" attribute.
+const SignatureHeader = "X-Shovel-Signature"
+
+// JobNotification is the summary sent to callbackUrl and notifyTopic once a
+// job completed, failed or was cancelled
+type JobNotification struct {
+	Event string `json:"event"` // "job.completed", "job.failed" or "job.cancelled"
+	JobStatus
+}
+
+// notifyHTTPClient sends callback requests
+var notifyHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	// pendingNotifications holds a channel per notification still being
+	// delivered in the background, closed once it is done
+	pendingNotifications   = make(map[chan struct{}]bool)
+	pendingNotificationsMu sync.Mutex
+)
+
+// notifySecret returns the key used to sign notifications
+func notifySecret() string {
+	return os.Getenv("SHOVEL_NOTIFY_SECRET")
+}
+
+// validateNotification validates the optional callback settings of a request
+func validateNotification(req *ShovelRequest) error {
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("callbackUrl must be an absolute http or https URL")
+		}
+	}
+	if req.NotifyTopic != "" {
+		return normalizeResourceName("notifyTopic", &req.NotifyTopic, ParseTopic)
+	}
+	return nil
+}
+
+// signPayload returns the signature of payload in the form "sha256=<hex>".
+// It returns an empty string when no secret is configured.
+func signPayload(payload []byte, secret string) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyInBackground delivers the final job summary without holding up the
+// job. Retries of a slow callback take up to notifyTimeout.
+func (j *Job) notifyInBackground() {
+	done := make(chan struct{})
+	pendingNotificationsMu.Lock()
+	pendingNotifications[done] = true
+	pendingNotificationsMu.Unlock()
+	go func() {
+		defer func() {
+			pendingNotificationsMu.Lock()
+			delete(pendingNotifications, done)
+			pendingNotificationsMu.Unlock()
+			close(done)
+		}()
+		j.notify()
+	}()
+}
+
+// FlushNotifications waits until the notifications of finished jobs are
+// delivered. Call it on shutdown once all jobs finished and before
+// CloseClients.
+func FlushNotifications(ctx context.Context) error {
+	pendingNotificationsMu.Lock()
+	pending := make([]chan struct{}, 0, len(pendingNotifications))
+	for done := range pendingNotifications {
+		pending = append(pending, done)
+	}
+	pendingNotificationsMu.Unlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to deliver notifications: %v", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// notify delivers the final job summary to the configured callback and topic.
+// Interrupted jobs are not reported since they may still be resumed.
+func (j *Job) notify() {
+	if j.Request.CallbackURL == "" && j.Request.NotifyTopic == "" {
+		return
+	}
+	status := j.Status()
+	if status.State == JobStateInterrupted {
+		return
+	}
+
+	payload, err := json.Marshal(JobNotification{Event: "job." + string(status.State), JobStatus: status})
+	if err != nil {
+		j.log(context.Background(), slog.LevelError, "Failed to encode notification", "error", err)
+		return
+	}
+	signature := signPayload(payload, notifySecret())
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if j.Request.CallbackURL != "" {
+		if err := postCallback(ctx, j.Request.CallbackURL, payload, signature); err != nil {
+			j.log(ctx, slog.LevelWarn, "Failed to notify callback", "callbackUrl", j.Request.CallbackURL, "error", err)
+		}
+	}
+	if j.Request.NotifyTopic != "" {
+		attributes := map[string]string{"jobId": j.ID, "state": string(status.State)}
+		if signature != "" {
+			attributes["signature"] = signature
+		}
+		if err := publishNotification(ctx, j.Request.NotifyTopic, payload, attributes); err != nil {
+			j.log(ctx, slog.LevelWarn, "Failed to publish notification", "notifyTopic", j.Request.NotifyTopic, "error", err)
+		}
+	}
+}
+
+// postCallback posts payload to callbackURL, retrying network errors and
+// server errors with a short backoff
+func postCallback(ctx context.Context, callbackURL string, payload []byte, signature string) error {
+	var lastErr error
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("giving up after %d attempts: %v", attempt-1, lastErr)
+			case <-time.After(time.Duration(attempt-1) * time.Second):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if signature != "" {
+			req.Header.Set(SignatureHeader, signature)
+		}
+
+		resp, err := notifyHTTPClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("callback returned %s", resp.Status)
+		default:
+			return fmt.Errorf("callback returned %s", resp.Status)
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %v", notifyAttempts, lastErr)
+}
+
+// publishNotification publishes payload to topic and waits for the result
+func publishNotification(ctx context.Context, topic string, payload []byte, attributes map[string]string) error {
+	name, err := ParseTopic(topic)
+	if err != nil {
+		return err
+	}
+	client, err := pubsubClient(ctx, name.Project, "")
+	if err != nil {
+		return err
+	}
+
+	t := client.TopicInProject(name.ID, name.Project)
+	defer t.Stop()
+	if _, err := t.Publish(ctx, &pubsub.Message{Data: payload, Attributes: attributes}).Get(ctx); err != nil {
+		return fmt.Errorf("failed to publish: %v", err)
+	}
+	return nil
+}
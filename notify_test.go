@@ -0,0 +1,163 @@
+package shovel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidateNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ShovelRequest
+		wantErr bool
+	}{
+		{name: "none", req: ShovelRequest{}},
+		{name: "https callback", req: ShovelRequest{CallbackURL: "https://example.com/hooks/shovel"}},
+		{name: "relative callback", req: ShovelRequest{CallbackURL: "/hooks/shovel"}, wantErr: true},
+		{name: "unsupported scheme", req: ShovelRequest{CallbackURL: "ftp://example.com"}, wantErr: true},
+		{name: "notify topic", req: ShovelRequest{NotifyTopic: "projects/test/topics/events"}},
+		{name: "invalid notify topic", req: ShovelRequest{NotifyTopic: "events"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNotification(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	if sig := signPayload([]byte("{}"), ""); sig != "" {
+		t.Errorf("Expected no signature without a secret, got %q", sig)
+	}
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13"
+	if sig := signPayload([]byte("{}"), "secret"); sig != expected {
+		t.Errorf("Expected signature %q, got %q", expected, sig)
+	}
+}
+
+// flushNotifications waits until the notifications of finished jobs are delivered
+func flushNotifications(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := FlushNotifications(ctx); err != nil {
+		t.Fatalf("Failed to flush notifications: %v", err)
+	}
+}
+
+func TestNotify_Callback(t *testing.T) {
+	t.Setenv("SHOVEL_NOTIFY_SECRET", "secret")
+
+	var mu sync.Mutex
+	var attempts int
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	job := newJob("shovel-notify-test", ShovelRequest{CallbackURL: srv.URL})
+	job.tryAccept(0)
+	job.recordProcessed()
+	job.Cancel()
+	job.finish(nil)
+	flushNotifications(t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Fatalf("Expected the callback to be retried once, got %d attempts", attempts)
+	}
+	var notification JobNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		t.Fatalf("Failed to decode notification: %v", err)
+	}
+	if notification.Event != "job.cancelled" || notification.ID != job.ID || notification.ProcessedCount != 1 || notification.StopReason != StopReasonCancelled {
+		t.Errorf("Unexpected notification: %+v", notification)
+	}
+	if signature != signPayload(body, "secret") {
+		t.Errorf("Expected signature %q, got %q", signPayload(body, "secret"), signature)
+	}
+}
+
+func TestNotify_Topic(t *testing.T) {
+	srv, _ := newTestTopic(t, "events")
+	useTestServer(t, srv)
+
+	job := newJob("shovel-notify-topic-test", ShovelRequest{NotifyTopic: "projects/test/topics/events"})
+	job.recordFailed(errors.New("boom"))
+	job.finish(errors.New("receive failed"))
+	flushNotifications(t)
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(msgs))
+	}
+	if msgs[0].Attributes["jobId"] != job.ID || msgs[0].Attributes["state"] != string(JobStateFailed) {
+		t.Errorf("Unexpected notification attributes: %v", msgs[0].Attributes)
+	}
+	var notification JobNotification
+	if err := json.Unmarshal(msgs[0].Data, &notification); err != nil {
+		t.Fatalf("Failed to decode notification: %v", err)
+	}
+	if notification.Event != "job.failed" || notification.Error != "receive failed" || notification.ErrorCounts["Unknown"] != 1 {
+		t.Errorf("Unexpected notification: %+v", notification)
+	}
+}
+
+func TestNotify_SkipsInterruptedJobs(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer srv.Close()
+
+	job := newJob("shovel-notify-interrupted-test", ShovelRequest{CallbackURL: srv.URL})
+	job.interrupt()
+	job.finish(nil)
+	flushNotifications(t)
+
+	if calls != 0 {
+		t.Errorf("Expected no notification for an interrupted job, got %d", calls)
+	}
+}
+
+func TestNotify_DoesNotHoldUpJob(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+
+	job := newJob("shovel-notify-slow-test", ShovelRequest{CallbackURL: srv.URL})
+	job.Cancel()
+	start := time.Now()
+	job.finish(nil)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the job to be done before its notification is delivered, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := FlushNotifications(ctx); err == nil {
+		t.Error("Expected flushing to time out while the callback hangs")
+	}
+}
@@ -0,0 +1,224 @@
+package shovel
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// receiveIdleTimeout ends peek and export once no message arrived for this long
+const receiveIdleTimeout = 5 * time.Second
+
+// MessageRecord is the portable representation of a message used by peek,
+// export and import. Exports are written as one JSON record per line.
+type MessageRecord struct {
+	ID          string            `json:"id,omitempty"`
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+	PublishTime time.Time         `json:"publishTime,omitempty"`
+}
+
+// Run validates req and executes it synchronously in the calling process.
+// progress, if set, is called every interval with the current job status.
+// Once ctx is done, the job is cancelled and ends in state cancelled.
+func Run(ctx context.Context, req ShovelRequest, interval time.Duration, progress func(JobStatus)) (JobStatus, error) {
+	if err := validateRequest(ctx, &req); err != nil {
+		return JobStatus{}, err
+	}
+	if err := preflight(ctx, req); err != nil {
+		return JobStatus{}, err
+	}
+
+	job := newJob(newRequestID(), req)
+	// The job context only ends through Cancel, which records why
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	job.cancel = cancel
+	stop := context.AfterFunc(ctx, func() { job.Cancel() })
+	defer stop()
+
+	if progress != nil {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-job.Done():
+					return
+				case <-ticker.C:
+					progress(job.Status())
+				}
+			}
+		}()
+	}
+
+	job.run(jobCtx)
+	status := job.Status()
+	if status.State == JobStateFailed {
+		return status, errors.New(status.Error)
+	}
+	return status, nil
+}
+
+// Peek returns up to max messages from subscription without consuming them
+func Peek(ctx context.Context, subscription string, max int) ([]MessageRecord, error) {
+	var records []MessageRecord
+	_, err := withSubscription(ctx, subscription, func(sub *pubsub.Subscription) (int, error) {
+		return receiveMessages(ctx, sub, max, false, receiveIdleTimeout, func(msg *pubsub.Message) error {
+			records = append(records, toMessageRecord(msg))
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Export writes up to max messages from subscription to w as JSON lines.
+// Messages are only acknowledged, and thereby removed from the subscription,
+// when ack is true and after they were written successfully.
+func Export(ctx context.Context, subscription string, max int, ack bool, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	return withSubscription(ctx, subscription, func(sub *pubsub.Subscription) (int, error) {
+		return receiveMessages(ctx, sub, max, ack, receiveIdleTimeout, func(msg *pubsub.Message) error {
+			return enc.Encode(toMessageRecord(msg))
+		})
+	})
+}
+
+// Import publishes the JSON lines read from r to topic
+func Import(ctx context.Context, topic string, r io.Reader) (int, error) {
+	name, err := ParseTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	client, err := newPubsubClient(ctx, name.Project)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create pubsub client: %v", err)
+	}
+	defer client.Close()
+
+	targetTopic := client.TopicInProject(name.ID, name.Project)
+	targetTopic.EnableMessageOrdering = true
+	defer targetTopic.Stop()
+
+	return importMessages(ctx, targetTopic, r)
+}
+
+// importMessages publishes every record read from r and waits for all results
+func importMessages(ctx context.Context, topic *pubsub.Topic, r io.Reader) (int, error) {
+	var results []*pubsub.PublishResult
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record MessageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return 0, fmt.Errorf("invalid record on line %d: %v", line, err)
+		}
+		results = append(results, topic.Publish(ctx, &pubsub.Message{
+			Data:        record.Data,
+			Attributes:  record.Attributes,
+			OrderingKey: record.OrderingKey,
+		}))
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read records: %v", err)
+	}
+
+	published := 0
+	for _, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			return published, fmt.Errorf("failed to publish message: %v", err)
+		}
+		published++
+	}
+	return published, nil
+}
+
+// withSubscription creates a client for subscription and passes the handle to fn
+func withSubscription(ctx context.Context, subscription string, fn func(*pubsub.Subscription) (int, error)) (int, error) {
+	name, err := ParseSubscription(subscription)
+	if err != nil {
+		return 0, err
+	}
+	client, err := newPubsubClient(ctx, name.Project)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create pubsub client: %v", err)
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			logger.Warn("Failed to close pubsub client", "error", err)
+		}
+	}()
+	return fn(client.SubscriptionInProject(name.ID, name.Project))
+}
+
+// receiveMessages passes up to max messages to fn, one at a time. With ack,
+// each message is acked once fn succeeded. Otherwise messages are held until
+// receiving stopped and then nacked, so none is handed to fn twice. Receiving
+// also stops when no message arrived within idle.
+func receiveMessages(ctx context.Context, sub *pubsub.Subscription, max int, ack bool, idle time.Duration, fn func(*pubsub.Message) error) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sub.ReceiveSettings.MaxOutstandingMessages = max
+	idleTimer := time.AfterFunc(idle, cancel)
+	defer idleTimer.Stop()
+
+	var mutex sync.Mutex
+	var count int
+	var fnErr error
+	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		mutex.Lock()
+		if count >= max || fnErr != nil {
+			mutex.Unlock()
+			msg.Nack()
+			return
+		}
+		idleTimer.Reset(idle)
+		if err := fn(msg); err != nil {
+			fnErr = err
+			mutex.Unlock()
+			msg.Nack()
+			cancel()
+			return
+		}
+		count++
+		if count >= max {
+			cancel()
+		}
+		mutex.Unlock()
+
+		if ack {
+			msg.Ack()
+			return
+		}
+		// Hold the message until receiving stopped
+		<-ctx.Done()
+		msg.Nack()
+	})
+	if err != nil {
+		return count, fmt.Errorf("receive failed: %v", err)
+	}
+	return count, fnErr
+}
+
+// toMessageRecord converts a received message into its portable form
+func toMessageRecord(msg *pubsub.Message) MessageRecord {
+	return MessageRecord{
+		ID:          msg.ID,
+		Data:        msg.Data,
+		Attributes:  msg.Attributes,
+		OrderingKey: msg.OrderingKey,
+		PublishTime: msg.PublishTime,
+	}
+}
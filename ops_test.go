@@ -0,0 +1,148 @@
+package shovel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+// newTestSubscription creates a topic with one subscription holding n messages
+func newTestSubscription(t *testing.T, n int) (*pstest.Server, *pubsub.Subscription) {
+	t.Helper()
+	ctx := context.Background()
+	srv, client := newTestClient(t)
+	topic, err := client.CreateTopic(ctx, "source")
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	sub, err := client.CreateSubscription(ctx, "source-sub", pubsub.SubscriptionConfig{Topic: topic})
+	if err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		srv.Publish("projects/test/topics/source", []byte(fmt.Sprintf("message-%d", i)), map[string]string{"index": fmt.Sprint(i)})
+	}
+	return srv, sub
+}
+
+// ackedCount returns the number of messages acknowledged at least once
+func ackedCount(srv *pstest.Server) int {
+	acked := 0
+	for _, m := range srv.Messages() {
+		if m.Acks > 0 {
+			acked++
+		}
+	}
+	return acked
+}
+
+func TestReceiveMessages_PeekDoesNotConsume(t *testing.T) {
+	srv, sub := newTestSubscription(t, 5)
+
+	seen := map[string]bool{}
+	count, err := receiveMessages(context.Background(), sub, 3, false, 500*time.Millisecond, func(msg *pubsub.Message) error {
+		if seen[msg.ID] {
+			t.Errorf("Message %s delivered twice", msg.ID)
+		}
+		seen[msg.ID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 messages, got %d", count)
+	}
+	if acked := ackedCount(srv); acked != 0 {
+		t.Errorf("Expected no acked messages, got %d", acked)
+	}
+}
+
+func TestReceiveMessages_ExportAcks(t *testing.T) {
+	srv, sub := newTestSubscription(t, 4)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	count, err := receiveMessages(context.Background(), sub, 10, true, 500*time.Millisecond, func(msg *pubsub.Message) error {
+		return enc.Encode(toMessageRecord(msg))
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 messages, got %d", count)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 4 {
+		t.Errorf("Expected 4 exported lines, got %d", lines)
+	}
+	time.Sleep(200 * time.Millisecond) // Acks are sent asynchronously
+	if acked := ackedCount(srv); acked != 4 {
+		t.Errorf("Expected 4 acked messages, got %d", acked)
+	}
+}
+
+func TestImportMessages(t *testing.T) {
+	srv, topic := newTestTopic(t, "target")
+	input := `{"data":"aGVsbG8=","attributes":{"type":"greeting"},"orderingKey":"k1"}
+
+{"data":"d29ybGQ="}
+`
+	count, err := importMessages(context.Background(), topic, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 imported messages, got %d", count)
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 published messages, got %d", len(msgs))
+	}
+	for _, m := range msgs {
+		if string(m.Data) == "hello" && (m.Attributes["type"] != "greeting" || m.OrderingKey != "k1") {
+			t.Errorf("Expected attributes and ordering key to be preserved, got %v %q", m.Attributes, m.OrderingKey)
+		}
+	}
+}
+
+func TestImportMessages_InvalidRecord(t *testing.T) {
+	_, topic := newTestTopic(t, "target")
+	if _, err := importMessages(context.Background(), topic, strings.NewReader("not json\n")); err == nil {
+		t.Error("Expected invalid record to be rejected")
+	}
+}
+
+func TestRun_InvalidRequest(t *testing.T) {
+	if _, err := Run(context.Background(), ShovelRequest{}, time.Second, nil); err == nil {
+		t.Error("Expected invalid request to be rejected")
+	}
+}
+
+func TestRun_CancelledByCaller(t *testing.T) {
+	useTestServer(t, newShovelFixture(t, 3))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+
+	status, err := Run(ctx, ShovelRequest{
+		Mode:               ModeContinuous,
+		SourceSubscription: "projects/test/subscriptions/source-sub",
+		TargetTopic:        "projects/test/topics/target",
+	}, time.Second, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.State != JobStateCancelled || status.StopReason != StopReasonCancelled {
+		t.Errorf("Expected the job to end cancelled, got %s (%s)", status.State, status.StopReason)
+	}
+	if status.ProcessedCount != 3 {
+		t.Errorf("Expected 3 processed messages, got %d", status.ProcessedCount)
+	}
+}
@@ -0,0 +1,66 @@
+package shovel
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// orderingPauseTimeout releases a paused key whose failed message was not
+// redelivered, e.g. because it went to a dead letter topic or expired. Pub/Sub
+// redelivers a nacked message within its maximum retry backoff of 600 seconds.
+var orderingPauseTimeout = 10 * time.Minute
+
+// orderingPauses tracks the ordering keys paused by a failed publish. A key
+// stays paused until its failed message is redelivered or the pause times
+// out, so that later messages of the key cannot overtake it.
+type orderingPauses struct {
+	mu     sync.Mutex
+	failed map[string]orderingPause // By ordering key
+	now    func() time.Time
+}
+
+// orderingPause is the failed message a key waits for
+type orderingPause struct {
+	id       string
+	pausedAt time.Time
+}
+
+func newOrderingPauses() *orderingPauses {
+	return &orderingPauses{failed: make(map[string]orderingPause), now: time.Now}
+}
+
+// pause records that publishing msg failed with err. Messages rejected because
+// their key was paused already do not move the point to resume from.
+func (p *orderingPauses) pause(msg *pubsub.Message, err error) {
+	if msg.OrderingKey == "" || errors.As(err, &pubsub.ErrPublishingPaused{}) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.failed[msg.OrderingKey]; !ok {
+		p.failed[msg.OrderingKey] = orderingPause{id: msg.ID, pausedAt: p.now()}
+	}
+}
+
+// admit reports whether msg may be published. It reports resume when msg is
+// the redelivered failed message of its key or the pause timed out, and the
+// key must then be resumed on the publisher.
+func (p *orderingPauses) admit(msg *pubsub.Message) (ok, resume bool) {
+	if msg.OrderingKey == "" {
+		return true, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pause, paused := p.failed[msg.OrderingKey]
+	if !paused {
+		return true, false
+	}
+	if pause.id != msg.ID && p.now().Sub(pause.pausedAt) < orderingPauseTimeout {
+		return false, false
+	}
+	delete(p.failed, msg.OrderingKey)
+	return true, true
+}
@@ -0,0 +1,100 @@
+package shovel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+)
+
+func TestOrderingPauses(t *testing.T) {
+	p := newOrderingPauses()
+	first := &pubsub.Message{ID: "1", OrderingKey: "customer-1"}
+	second := &pubsub.Message{ID: "2", OrderingKey: "customer-1"}
+	other := &pubsub.Message{ID: "3", OrderingKey: "customer-2"}
+	unordered := &pubsub.Message{ID: "4"}
+
+	p.pause(first, errors.New("publish failed"))
+	p.pause(second, pubsub.ErrPublishingPaused{OrderingKey: "customer-1"})
+	p.pause(unordered, errors.New("publish failed"))
+
+	tests := []struct {
+		name           string
+		msg            *pubsub.Message
+		expectAdmitted bool
+		expectResume   bool
+	}{
+		{name: "successor of the failed message", msg: second},
+		{name: "other key", msg: other, expectAdmitted: true},
+		{name: "no key", msg: unordered, expectAdmitted: true},
+		{name: "redelivered failed message", msg: first, expectAdmitted: true, expectResume: true},
+		{name: "successor after resume", msg: second, expectAdmitted: true},
+	}
+
+	for _, tt := range tests {
+		admitted, resume := p.admit(tt.msg)
+		if admitted != tt.expectAdmitted || resume != tt.expectResume {
+			t.Errorf("%s: expected admitted=%v resume=%v, got %v and %v", tt.name, tt.expectAdmitted, tt.expectResume, admitted, resume)
+		}
+	}
+}
+
+func TestOrderingPauses_Timeout(t *testing.T) {
+	p := newOrderingPauses()
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	failed := &pubsub.Message{ID: "1", OrderingKey: "customer-1"}
+	successor := &pubsub.Message{ID: "2", OrderingKey: "customer-1"}
+
+	// The failed message went to a dead letter topic and never comes back
+	p.pause(failed, errors.New("publish failed"))
+	now = now.Add(orderingPauseTimeout - time.Second)
+	if admitted, _ := p.admit(successor); admitted {
+		t.Error("Expected the successor to be handed back before the timeout")
+	}
+	now = now.Add(time.Second)
+	if admitted, resume := p.admit(successor); !admitted || !resume {
+		t.Errorf("Expected the key to be resumed after the timeout, got admitted=%v resume=%v", admitted, resume)
+	}
+	if admitted, resume := p.admit(failed); !admitted || resume {
+		t.Errorf("Expected the key to stay resumed, got admitted=%v resume=%v", admitted, resume)
+	}
+}
+
+func TestFaults_OrderingWithPublishErrors(t *testing.T) {
+	useTimeouts(t, time.Minute, 5*time.Second)
+	faults := &faultInjector{publishErrorRate: 0.3, publishCode: codes.PermissionDenied}
+	e := newIntegrationEnv(t, faults.dialOptions()...)
+	keys := []string{"customer-1", "customer-2", "customer-3"}
+	var msgs []*pubsub.Message
+	for i := 0; i < 10; i++ {
+		for _, key := range keys {
+			msgs = append(msgs, &pubsub.Message{Data: []byte(fmt.Sprintf("%s-%d", key, i)), OrderingKey: key})
+		}
+	}
+	e.publish(msgs...)
+
+	// Failed messages are redelivered before their successors are published
+	status := e.run(ShovelRequest{AllMessages: true})
+	if failures, _, _ := faults.counts(); failures == 0 || status.FailedCount == 0 {
+		t.Fatalf("Expected publish failures to be injected, got %d", failures)
+	}
+
+	sequences := map[string][]string{}
+	for _, msg := range e.published(len(msgs)) {
+		sequences[msg.OrderingKey] = append(sequences[msg.OrderingKey], string(msg.Data))
+	}
+	for _, key := range keys {
+		var want []string
+		for i := 0; i < 10; i++ {
+			want = append(want, fmt.Sprintf("%s-%d", key, i))
+		}
+		if strings.Join(sequences[key], ",") != strings.Join(want, ",") {
+			t.Errorf("Expected %s in order %v, got %v", key, want, sequences[key])
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package shovel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// PolicyRule matches requests by caller, source and target. Empty fields
+// match everything, so a rule with only sources and targets forbids or allows
+// a source→target pair for all callers.
+//
+// ServiceAccounts, Brokers, NotifyTopics and Callbacks are different: an
+// allow rule only covers requests whose impersonated service accounts, Kafka
+// brokers, notification topic and callback URL all match it, so these have
+// to be allowed explicitly. A deny rule applies as soon as one of them
+// matches.
+type PolicyRule struct {
+	Name            string   `json:"name"`
+	Principals      []string `json:"principals,omitempty"`      // Principal patterns, e.g. "apikey:ops" or "idtoken:*@my-project.iam.gserviceaccount.com"
+	Sources         []string `json:"sources,omitempty"`         // Source patterns, e.g. "projects/prod-*/subscriptions/*" or "kafka:orders"
+	Targets         []string `json:"targets,omitempty"`         // Target topic patterns, e.g. "projects/*/topics/dev-*"
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"` // Patterns of service accounts the request may impersonate
+	Brokers         []string `json:"brokers,omitempty"`         // Patterns of Kafka brokers the request may read from, e.g. "kafka-*.internal:9092"
+	NotifyTopics    []string `json:"notifyTopics,omitempty"`    // Patterns of topics the request may send its summary to
+	Callbacks       []string `json:"callbacks,omitempty"`       // Patterns of callback URLs, e.g. "https://hooks.example.com/*"
+}
+
+// PolicyRequest is what the policy decides on
+type PolicyRequest struct {
+	Principal       Principal
+	Source          string
+	Target          string
+	ServiceAccounts []string // Accounts the request impersonates
+	Brokers         []string // Brokers of a Kafka source
+	NotifyTopic     string
+	CallbackURL     string
+}
+
+// policyRequest describes req of principal to the policy
+func policyRequest(principal Principal, req ShovelRequest) PolicyRequest {
+	r := PolicyRequest{
+		Principal:       principal,
+		Source:          metricsSource(req),
+		Target:          req.TargetTopic,
+		ServiceAccounts: req.serviceAccounts(),
+		NotifyTopic:     req.NotifyTopic,
+		CallbackURL:     req.CallbackURL,
+	}
+	if req.SourceKafka != nil {
+		r.Brokers = req.SourceKafka.Brokers
+	}
+	return r
+}
+
+// explicit returns the values of r that rules have to allow explicitly,
+// paired with the patterns of rule covering them
+func (r PolicyRequest) explicit(rule PolicyRule) [][2][]string {
+	return [][2][]string{
+		{r.ServiceAccounts, rule.ServiceAccounts},
+		{r.Brokers, rule.Brokers},
+		{nonEmpty(r.NotifyTopic), rule.NotifyTopics},
+		{nonEmpty(r.CallbackURL), rule.Callbacks},
+	}
+}
+
+// nonEmpty returns v as a list, or nil when it is empty
+func nonEmpty(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+// Policy restricts which sources and targets callers may use. A request
+// matching a deny rule is rejected. When allow rules exist, a request must
+// also match one of them.
+type Policy struct {
+	Allow []PolicyRule `json:"allow,omitempty"`
+	Deny  []PolicyRule `json:"deny,omitempty"`
+}
+
+// PolicyError is returned when a request violates the policy
+type PolicyError struct {
+	Rule string // Name of the violating rule, empty when no allow rule matched
+	PolicyRequest
+}
+
+func (e *PolicyError) Error() string {
+	action := fmt.Sprintf("shoveling from %s to %s", e.Source, e.Target)
+	if len(e.ServiceAccounts) > 0 {
+		action += " as " + strings.Join(e.ServiceAccounts, " and ")
+	}
+	if len(e.Brokers) > 0 {
+		action += " via brokers " + strings.Join(e.Brokers, ", ")
+	}
+	if notify := append(nonEmpty(e.NotifyTopic), nonEmpty(e.CallbackURL)...); len(notify) > 0 {
+		action += " notifying " + strings.Join(notify, " and ")
+	}
+	if e.Rule == "" {
+		return action + " is not allowed by any policy rule"
+	}
+	return fmt.Sprintf("%s is denied by policy rule %q", action, e.Rule)
+}
+
+// policy is enforced on all requests. nil allows everything but impersonation.
+var policy *Policy
+
+func init() {
+	p, err := LoadPolicyFromEnv()
+	if err != nil {
+		logger.Error("Failed to load policy, rejecting all requests", "error", err)
+		p = &Policy{Deny: []PolicyRule{{Name: "invalid-policy"}}}
+	}
+	policy = p
+}
+
+// SetPolicy replaces the policy. nil allows everything but impersonation.
+func SetPolicy(p *Policy) {
+	policy = p
+}
+
+// LoadPolicyFromEnv reads the policy from the JSON file named by
+// SHOVEL_POLICY_FILE or the inline JSON of SHOVEL_POLICY. It returns nil when
+// neither is set.
+func LoadPolicyFromEnv() (*Policy, error) {
+	data := []byte(os.Getenv("SHOVEL_POLICY"))
+	if file := os.Getenv("SHOVEL_POLICY_FILE"); file != "" {
+		var err error
+		data, err = os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy file: %v", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses and checks a JSON policy
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	}
+	for _, rules := range [][]PolicyRule{p.Allow, p.Deny} {
+		for i, rule := range rules {
+			if rule.Name == "" {
+				return nil, fmt.Errorf("invalid policy: rule %d has no name", i)
+			}
+			for _, patterns := range [][]string{rule.Principals, rule.Sources, rule.Targets, rule.ServiceAccounts, rule.Brokers, rule.NotifyTopics, rule.Callbacks} {
+				for _, pattern := range patterns {
+					if _, err := path.Match(pattern, ""); err != nil {
+						return nil, fmt.Errorf("invalid policy: rule %q has bad pattern %q", rule.Name, pattern)
+					}
+				}
+			}
+		}
+	}
+	return &p, nil
+}
+
+// PolicyDecision is the outcome of checking a request against the policy
+type PolicyDecision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"` // Matching allow rule or violating deny rule
+}
+
+// Decide checks whether the request r is allowed. Impersonation always needs
+// an allow rule, even without a policy: otherwise any caller could act as
+// every account the shovel may impersonate.
+func (p *Policy) Decide(r PolicyRequest) PolicyDecision {
+	impersonates := len(r.ServiceAccounts) > 0
+	if p == nil {
+		return PolicyDecision{Allowed: !impersonates}
+	}
+	for _, rule := range p.Deny {
+		if rule.matches(r) && rule.deniesAny(r) {
+			return PolicyDecision{Rule: rule.Name}
+		}
+	}
+	if len(p.Allow) == 0 {
+		return PolicyDecision{Allowed: !impersonates}
+	}
+	for _, rule := range p.Allow {
+		if rule.matches(r) && rule.allowsAll(r) {
+			return PolicyDecision{Allowed: true, Rule: rule.Name}
+		}
+	}
+	return PolicyDecision{}
+}
+
+// Check returns a PolicyError when the request r is not allowed
+func (p *Policy) Check(r PolicyRequest) error {
+	if d := p.Decide(r); !d.Allowed {
+		return &PolicyError{Rule: d.Rule, PolicyRequest: r}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to the request
+func (rule PolicyRule) matches(r PolicyRequest) bool {
+	return matchesPatterns(rule.Principals, r.Principal.String()) &&
+		matchesPatterns(rule.Sources, r.Source) &&
+		matchesPatterns(rule.Targets, r.Target)
+}
+
+// allowsAll reports whether the allow rule covers all explicitly allowed
+// values of the request
+func (rule PolicyRule) allowsAll(r PolicyRequest) bool {
+	for _, e := range r.explicit(rule) {
+		for _, value := range e[0] {
+			if !matchesAny(e[1], value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// deniesAny reports whether the deny rule applies to the explicitly allowed
+// values of the request. Deny rules without such patterns apply to every
+// request.
+func (rule PolicyRule) deniesAny(r PolicyRequest) bool {
+	restricted := false
+	for _, e := range r.explicit(rule) {
+		if len(e[1]) == 0 {
+			continue
+		}
+		restricted = true
+		for _, value := range e[0] {
+			if matchesAny(e[1], value) {
+				return true
+			}
+		}
+	}
+	return !restricted
+}
+
+// matchesPatterns reports whether name matches one of the patterns. No
+// patterns match every name.
+func matchesPatterns(patterns []string, name string) bool {
+	return len(patterns) == 0 || matchesAny(patterns, name)
+}
+
+// checkPolicy enforces the policy for the caller of ctx
+func checkPolicy(ctx context.Context, req *ShovelRequest) error {
+	return policy.Check(policyRequest(PrincipalFromContext(ctx), *req))
+}
@@ -0,0 +1,269 @@
+package shovel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `{
+  "allow": [
+    {"name": "ops-anything", "principals": ["apikey:ops"]},
+    {"name": "dlq-replay", "sources": ["projects/*/subscriptions/*-dlq"]},
+    {"name": "kafka-import", "sources": ["kafka:*"], "targets": ["projects/ingest/topics/*"], "brokers": ["kafka-*.internal:9092"]}
+  ],
+  "deny": [
+    {"name": "prod-to-dev", "sources": ["projects/prod/subscriptions/*"], "targets": ["projects/dev/topics/*"]},
+    {"name": "no-audit-writes", "targets": ["projects/*/topics/audit"]}
+  ]
+}`
+
+// usePolicy installs p for the rest of the test
+func usePolicy(t *testing.T, p *Policy) {
+	t.Helper()
+	original := policy
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(original) })
+}
+
+func TestPolicy_Check(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	ops := Principal{Kind: PrincipalAPIKey, Name: "ops"}
+	ci := Principal{Kind: PrincipalIDToken, Name: "ci@test.iam.gserviceaccount.com"}
+
+	tests := []struct {
+		name         string
+		principal    Principal
+		source       string
+		target       string
+		brokers      []string
+		expectedRule string
+		expectDenied bool
+	}{
+		{name: "allowed principal", principal: ops, source: "projects/prod/subscriptions/orders", target: "projects/prod/topics/orders"},
+		{name: "allowed source", principal: ci, source: "projects/prod/subscriptions/orders-dlq", target: "projects/prod/topics/orders"},
+		{name: "allowed kafka import", principal: ci, source: "kafka:orders", target: "projects/ingest/topics/orders", brokers: []string{"kafka-1.internal:9092", "kafka-2.internal:9092"}},
+		{name: "kafka import from foreign brokers", principal: ci, source: "kafka:orders", target: "projects/ingest/topics/orders", brokers: []string{"kafka-1.internal:9092", "attacker.example.com:9092"}, expectDenied: true},
+		{name: "no allow rule", principal: ci, source: "projects/prod/subscriptions/orders", target: "projects/prod/topics/orders", expectDenied: true},
+		{name: "kafka to other project", principal: ci, source: "kafka:orders", target: "projects/prod/topics/orders", brokers: []string{"kafka-1.internal:9092"}, expectDenied: true},
+		{name: "forbidden pair", principal: ops, source: "projects/prod/subscriptions/orders", target: "projects/dev/topics/orders", expectedRule: "prod-to-dev", expectDenied: true},
+		{name: "forbidden pair for allowed source", principal: ci, source: "projects/prod/subscriptions/orders-dlq", target: "projects/dev/topics/orders", expectedRule: "prod-to-dev", expectDenied: true},
+		{name: "forbidden target", principal: ops, source: "projects/dev/subscriptions/orders", target: "projects/dev/topics/audit", expectedRule: "no-audit-writes", expectDenied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(PolicyRequest{Principal: tt.principal, Source: tt.source, Target: tt.target, Brokers: tt.brokers})
+			if !tt.expectDenied {
+				if err != nil {
+					t.Errorf("Expected request to be allowed, got %v", err)
+				}
+				return
+			}
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Expected policy error, got %v", err)
+			}
+			if policyErr.Rule != tt.expectedRule {
+				t.Errorf("Expected rule %q, got %q", tt.expectedRule, policyErr.Rule)
+			}
+		})
+	}
+
+	var none *Policy
+	if err := none.Check(PolicyRequest{Principal: ci, Source: "projects/prod/subscriptions/orders", Target: "projects/dev/topics/orders"}); err != nil {
+		t.Errorf("Expected nil policy to allow everything, got %v", err)
+	}
+}
+
+func TestPolicy_ImpersonationNeedsAllowRule(t *testing.T) {
+	denyOnly, err := ParsePolicy([]byte(`{"deny": [{"name": "no-audit-writes", "targets": ["projects/*/topics/audit"]}]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	anonymous := Principal{}
+	r := PolicyRequest{Principal: anonymous, Source: "projects/a/subscriptions/orders", Target: "projects/b/topics/orders"}
+
+	for name, p := range map[string]*Policy{"no policy": nil, "deny rules only": denyOnly} {
+		if d := p.Decide(r); !d.Allowed {
+			t.Errorf("%s: expected requests without impersonation to be allowed, got %+v", name, d)
+		}
+		r.ServiceAccounts = []string{"writer@prod.iam.gserviceaccount.com"}
+		var policyErr *PolicyError
+		if err := p.Check(r); !errors.As(err, &policyErr) || policyErr.Rule != "" {
+			t.Errorf("%s: expected impersonation to be rejected without an allow rule, got %v", name, err)
+		}
+		r.ServiceAccounts = nil
+	}
+}
+
+func TestPolicy_ServiceAccounts(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{
+  "allow": [
+    {"name": "team-a", "principals": ["apikey:team-a"], "serviceAccounts": ["*@team-a.iam.gserviceaccount.com"]},
+    {"name": "ops", "principals": ["apikey:ops"]}
+  ],
+  "deny": [
+    {"name": "no-prod-writer", "serviceAccounts": ["writer@prod.iam.gserviceaccount.com"]}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	teamA := Principal{Kind: PrincipalAPIKey, Name: "team-a"}
+	ops := Principal{Kind: PrincipalAPIKey, Name: "ops"}
+	source, target := "projects/a/subscriptions/orders", "projects/b/topics/orders"
+
+	tests := []struct {
+		name            string
+		principal       Principal
+		serviceAccounts []string
+		expectedRule    string
+		expectAllowed   bool
+	}{
+		{name: "own accounts", principal: teamA, serviceAccounts: []string{"reader@team-a.iam.gserviceaccount.com", "writer@team-a.iam.gserviceaccount.com"}, expectedRule: "team-a", expectAllowed: true},
+		{name: "no impersonation", principal: teamA, expectedRule: "team-a", expectAllowed: true},
+		{name: "foreign account", principal: teamA, serviceAccounts: []string{"reader@team-a.iam.gserviceaccount.com", "writer@team-b.iam.gserviceaccount.com"}},
+		{name: "rule without accounts", principal: ops, serviceAccounts: []string{"reader@team-a.iam.gserviceaccount.com"}},
+		{name: "rule without accounts, no impersonation", principal: ops, expectedRule: "ops", expectAllowed: true},
+		{name: "denied account", principal: teamA, serviceAccounts: []string{"reader@team-a.iam.gserviceaccount.com", "writer@prod.iam.gserviceaccount.com"}, expectedRule: "no-prod-writer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Decide(PolicyRequest{Principal: tt.principal, Source: source, Target: target, ServiceAccounts: tt.serviceAccounts})
+			if d.Allowed != tt.expectAllowed || d.Rule != tt.expectedRule {
+				t.Errorf("Expected allowed=%v by %q, got %+v", tt.expectAllowed, tt.expectedRule, d)
+			}
+		})
+	}
+
+	err = p.Check(PolicyRequest{Principal: ops, Source: source, Target: target, ServiceAccounts: []string{"reader@team-a.iam.gserviceaccount.com"}})
+	if err == nil || !strings.Contains(err.Error(), "as reader@team-a.iam.gserviceaccount.com") {
+		t.Errorf("Expected the error to name the impersonated account, got %v", err)
+	}
+}
+
+func TestPolicy_Notifications(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{
+  "allow": [
+    {"name": "ops", "principals": ["apikey:ops"], "notifyTopics": ["projects/ops/topics/*"], "callbacks": ["https://hooks.example.com/*"]},
+    {"name": "ci", "principals": ["apikey:ci"]}
+  ],
+  "deny": [
+    {"name": "no-audit-notifications", "notifyTopics": ["projects/*/topics/audit"]}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	ops := Principal{Kind: PrincipalAPIKey, Name: "ops"}
+	ci := Principal{Kind: PrincipalAPIKey, Name: "ci"}
+	source, target := "projects/a/subscriptions/orders", "projects/b/topics/orders"
+
+	tests := []struct {
+		name          string
+		principal     Principal
+		notifyTopic   string
+		callbackURL   string
+		expectedRule  string
+		expectAllowed bool
+	}{
+		{name: "allowed topic and callback", principal: ops, notifyTopic: "projects/ops/topics/jobs", callbackURL: "https://hooks.example.com/shovel", expectedRule: "ops", expectAllowed: true},
+		{name: "foreign topic", principal: ops, notifyTopic: "projects/prod/topics/orders"},
+		{name: "internal callback", principal: ops, callbackURL: "http://metadata.google.internal/computeMetadata/v1"},
+		{name: "rule without notifications", principal: ci, callbackURL: "https://hooks.example.com/shovel"},
+		{name: "rule without notifications, none requested", principal: ci, expectedRule: "ci", expectAllowed: true},
+		{name: "denied topic", principal: ops, notifyTopic: "projects/ops/topics/audit", expectedRule: "no-audit-notifications"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Decide(PolicyRequest{Principal: tt.principal, Source: source, Target: target, NotifyTopic: tt.notifyTopic, CallbackURL: tt.callbackURL})
+			if d.Allowed != tt.expectAllowed || d.Rule != tt.expectedRule {
+				t.Errorf("Expected allowed=%v by %q, got %+v", tt.expectAllowed, tt.expectedRule, d)
+			}
+		})
+	}
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "invalid JSON", policy: `{"allow": [`},
+		{name: "unnamed rule", policy: `{"deny": [{"targets": ["projects/*/topics/audit"]}]}`},
+		{name: "bad pattern", policy: `{"allow": [{"name": "broken", "sources": ["projects/[/subscriptions/*"]}]}`},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParsePolicy([]byte(tt.policy)); err == nil {
+			t.Errorf("Expected error for %s, got none", tt.name)
+		}
+	}
+}
+
+func TestLoadPolicyFromEnv(t *testing.T) {
+	p, err := LoadPolicyFromEnv()
+	if err != nil || p != nil {
+		t.Errorf("Expected no policy without configuration, got %v, %v", p, err)
+	}
+
+	t.Setenv("SHOVEL_POLICY", `{"deny": [{"name": "inline"}]}`)
+	p, err = LoadPolicyFromEnv()
+	if err != nil || len(p.Deny) != 1 || p.Deny[0].Name != "inline" {
+		t.Errorf("Expected inline policy, got %v, %v", p, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(file, []byte(testPolicy), 0o600); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	t.Setenv("SHOVEL_POLICY_FILE", file)
+	p, err = LoadPolicyFromEnv()
+	if err != nil || len(p.Allow) != 3 {
+		t.Errorf("Expected policy file to take precedence, got %v, %v", p, err)
+	}
+
+	t.Setenv("SHOVEL_POLICY_FILE", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := LoadPolicyFromEnv(); err == nil {
+		t.Error("Expected error for missing policy file")
+	}
+}
+
+func TestHandler_PolicyViolation(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	usePolicy(t, p)
+
+	body, _ := json.Marshal(ShovelRequest{
+		NumMessages:        10,
+		SourceSubscription: "projects/prod/subscriptions/orders-dlq",
+		TargetTopic:        "projects/dev/topics/orders",
+	})
+	rr := httptest.NewRecorder()
+	Handler(rr, httptest.NewRequest("POST", "/", bytes.NewBuffer(body)))
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+	var response ShovelResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !strings.Contains(response.Message, `"prod-to-dev"`) {
+		t.Errorf("Expected message to name the violating rule, got %q", response.Message)
+	}
+}
@@ -0,0 +1,231 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// preflightTimeout bounds the checks that run before a job is accepted
+const preflightTimeout = 15 * time.Second
+
+// Preflight checks
+const (
+	PreflightExists     = "exists"
+	PreflightPermission = "permission"
+	PreflightSchema     = "schema"
+)
+
+// Permissions a job needs on its resources
+const (
+	permissionConsume = "pubsub.subscriptions.consume"
+	permissionPublish = "pubsub.topics.publish"
+)
+
+// PreflightFinding is a problem found before a job started
+type PreflightFinding struct {
+	Check    string `json:"check"` // PreflightExists, PreflightPermission or PreflightSchema
+	Resource string `json:"resource"`
+	Message  string `json:"message"`
+}
+
+// PreflightError is returned when a request cannot succeed with the resources
+// and permissions as they are
+type PreflightError struct {
+	Findings []PreflightFinding
+}
+
+func (e *PreflightError) Error() string {
+	messages := make([]string, len(e.Findings))
+	for i, f := range e.Findings {
+		messages[i] = f.Message
+	}
+	return "preflight checks failed: " + strings.Join(messages, "; ")
+}
+
+// preflight checks that the resources of a validated request exist, that the
+// job may consume from and publish to them, and that the target topic accepts
+// the messages of the source. It returns a *PreflightError listing all
+// findings, or another error when the checks themselves failed.
+func preflight(ctx context.Context, req ShovelRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	var findings []PreflightFinding
+	add := func(f *PreflightFinding) {
+		if f != nil {
+			findings = append(findings, *f)
+		}
+	}
+
+	var sourceSub *pubsub.Subscription
+	if req.SourceSubscription != "" {
+		source, err := ParseSubscription(req.SourceSubscription)
+		if err != nil {
+			return err
+		}
+		client, err := pubsubClient(ctx, source.Project, req.SourceServiceAccount)
+		if err != nil {
+			return err
+		}
+		sub := client.SubscriptionInProject(source.ID, source.Project)
+		f, err := checkAccess(ctx, req.SourceSubscription, sub.IAM().TestPermissions, sub.Exists, permissionConsume, req.SourceServiceAccount)
+		if err != nil {
+			return err
+		}
+		add(f)
+		if f == nil {
+			sourceSub = sub
+		}
+	}
+
+	target, err := ParseTopic(req.TargetTopic)
+	if err != nil {
+		return err
+	}
+	client, err := pubsubClient(ctx, target.Project, req.TargetServiceAccount)
+	if err != nil {
+		return err
+	}
+	targetTopic := client.TopicInProject(target.ID, target.Project)
+	f, err := checkAccess(ctx, req.TargetTopic, targetTopic.IAM().TestPermissions, targetTopic.Exists, permissionPublish, req.TargetServiceAccount)
+	if err != nil {
+		return err
+	}
+	add(f)
+	if f == nil && sourceSub != nil {
+		add(checkSchema(ctx, sourceSub, targetTopic))
+	}
+
+	// Notifications are published with the default credentials
+	if req.NotifyTopic != "" {
+		notify, err := ParseTopic(req.NotifyTopic)
+		if err != nil {
+			return err
+		}
+		client, err := pubsubClient(ctx, notify.Project, "")
+		if err != nil {
+			return err
+		}
+		topic := client.TopicInProject(notify.ID, notify.Project)
+		f, err := checkAccess(ctx, req.NotifyTopic, topic.IAM().TestPermissions, topic.Exists, permissionPublish, "")
+		if err != nil {
+			return err
+		}
+		add(f)
+	}
+
+	if len(findings) > 0 {
+		return &PreflightError{Findings: findings}
+	}
+	return nil
+}
+
+// checkAccess checks that resource exists and that serviceAccount, or the
+// default credentials, hold permission on it. Emulators do not implement IAM,
+// there only the existence is checked.
+func checkAccess(ctx context.Context, resource string, testPermissions func(context.Context, []string) ([]string, error), exists func(context.Context) (bool, error), permission, serviceAccount string) (*PreflightFinding, error) {
+	granted, err := testPermissions(ctx, []string{permission})
+	switch status.Code(err) {
+	case codes.OK:
+		if len(granted) == 0 {
+			return &PreflightFinding{
+				Check:    PreflightPermission,
+				Resource: resource,
+				Message:  fmt.Sprintf("%s is missing permission %s on %s", identity(serviceAccount), permission, resource),
+			}, nil
+		}
+		return nil, nil
+	case codes.NotFound:
+		return notFoundFinding(resource), nil
+	case codes.PermissionDenied:
+		return &PreflightFinding{
+			Check:    PreflightPermission,
+			Resource: resource,
+			Message:  fmt.Sprintf("%s has no access to %s", identity(serviceAccount), resource),
+		}, nil
+	case codes.Unimplemented:
+		ok, err := exists(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if %s exists: %v", resource, err)
+		}
+		if !ok {
+			return notFoundFinding(resource), nil
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("failed to check permissions on %s: %v", resource, err)
+	}
+}
+
+func notFoundFinding(resource string) *PreflightFinding {
+	return &PreflightFinding{
+		Check:    PreflightExists,
+		Resource: resource,
+		Message:  fmt.Sprintf("%s does not exist", resource),
+	}
+}
+
+// checkSchema checks that the target topic accepts the messages of the topic
+// sub is attached to: when the target validates messages against a schema,
+// the source topic must use the same schema and encoding. Configurations that
+// cannot be read are not checked, since reading them needs more than the
+// permissions a job requires.
+func checkSchema(ctx context.Context, sub *pubsub.Subscription, target *pubsub.Topic) *PreflightFinding {
+	targetConfig, err := target.Config(ctx)
+	if err != nil {
+		logger.Info("Skipped schema check", "targetTopic", target.String(), "error", err)
+		return nil
+	}
+	want := targetConfig.SchemaSettings
+	if want == nil || want.Schema == "" {
+		return nil
+	}
+
+	subConfig, err := sub.Config(ctx)
+	if err != nil || subConfig.Topic == nil {
+		logger.Info("Skipped schema check", "sourceSubscription", sub.String(), "error", err)
+		return nil
+	}
+	if subConfig.Topic.ID() == "_deleted-topic_" {
+		return nil
+	}
+	sourceConfig, err := subConfig.Topic.Config(ctx)
+	if err != nil {
+		logger.Info("Skipped schema check", "sourceTopic", subConfig.Topic.String(), "error", err)
+		return nil
+	}
+
+	finding := func(format string, args ...interface{}) *PreflightFinding {
+		prefix := fmt.Sprintf("target topic %s requires schema %s with %s encoding, but source topic %s ",
+			target.String(), want.Schema, encodingName(want.Encoding), subConfig.Topic.String())
+		return &PreflightFinding{Check: PreflightSchema, Resource: target.String(), Message: prefix + fmt.Sprintf(format, args...)}
+	}
+	have := sourceConfig.SchemaSettings
+	switch {
+	case have == nil || have.Schema == "":
+		return finding("has no schema")
+	case have.Schema != want.Schema:
+		return finding("uses schema %s", have.Schema)
+	case have.Encoding != want.Encoding:
+		return finding("uses %s encoding", encodingName(have.Encoding))
+	}
+	return nil
+}
+
+// encodingName returns the name of a schema encoding as shown by gcloud
+func encodingName(e pubsub.SchemaEncoding) string {
+	switch e {
+	case pubsub.EncodingJSON:
+		return "JSON"
+	case pubsub.EncodingBinary:
+		return "BINARY"
+	default:
+		return "unspecified"
+	}
+}
@@ -0,0 +1,158 @@
+package shovel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newSchemaFixture adds topics validated against schemas to the shovel
+// fixture: "orders" with subscription "orders-sub" uses the orders schema as
+// JSON, "orders-json", "orders-binary" and "payments" are possible targets
+func newSchemaFixture(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	srv := newShovelFixture(t, 0)
+	useTestServer(t, srv)
+	client, err := newPubsubClient(ctx, "test")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	topics := map[string]*pubsub.SchemaSettings{
+		"orders":        {Schema: "projects/test/schemas/orders", Encoding: pubsub.EncodingJSON},
+		"orders-json":   {Schema: "projects/test/schemas/orders", Encoding: pubsub.EncodingJSON},
+		"orders-binary": {Schema: "projects/test/schemas/orders", Encoding: pubsub.EncodingBinary},
+		"payments":      {Schema: "projects/test/schemas/payments", Encoding: pubsub.EncodingJSON},
+	}
+	for id, schema := range topics {
+		if _, err := client.CreateTopicWithConfig(ctx, id, &pubsub.TopicConfig{SchemaSettings: schema}); err != nil {
+			t.Fatalf("Failed to create topic: %v", err)
+		}
+	}
+	if _, err := client.CreateSubscription(ctx, "orders-sub", pubsub.SubscriptionConfig{Topic: client.Topic("orders")}); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	newSchemaFixture(t)
+
+	tests := []struct {
+		name           string
+		req            ShovelRequest
+		expectedChecks []string
+	}{
+		{name: "valid", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/source-sub", TargetTopic: "projects/test/topics/target"}},
+		{name: "missing subscription", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/missing", TargetTopic: "projects/test/topics/target"}, expectedChecks: []string{PreflightExists}},
+		{name: "missing target", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/source-sub", TargetTopic: "projects/test/topics/missing"}, expectedChecks: []string{PreflightExists}},
+		{name: "everything missing", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/missing", TargetTopic: "projects/test/topics/missing", NotifyTopic: "projects/test/topics/missing-notify"}, expectedChecks: []string{PreflightExists, PreflightExists, PreflightExists}},
+		{name: "kafka source", req: ShovelRequest{SourceKafka: &KafkaSource{Brokers: []string{"localhost:9092"}, Topic: "orders"}, TargetTopic: "projects/test/topics/orders-json"}},
+		{name: "same schema", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/orders-sub", TargetTopic: "projects/test/topics/orders-json"}},
+		{name: "target without schema", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/orders-sub", TargetTopic: "projects/test/topics/target"}},
+		{name: "source without schema", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/source-sub", TargetTopic: "projects/test/topics/orders-json"}, expectedChecks: []string{PreflightSchema}},
+		{name: "other schema", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/orders-sub", TargetTopic: "projects/test/topics/payments"}, expectedChecks: []string{PreflightSchema}},
+		{name: "other encoding", req: ShovelRequest{SourceSubscription: "projects/test/subscriptions/orders-sub", TargetTopic: "projects/test/topics/orders-binary"}, expectedChecks: []string{PreflightSchema}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := preflight(context.Background(), tt.req)
+			if len(tt.expectedChecks) == 0 {
+				if err != nil {
+					t.Errorf("Expected preflight checks to pass, got %v", err)
+				}
+				return
+			}
+			var preflightErr *PreflightError
+			if !errors.As(err, &preflightErr) {
+				t.Fatalf("Expected preflight error, got %v", err)
+			}
+			var checks []string
+			for _, f := range preflightErr.Findings {
+				checks = append(checks, f.Check)
+			}
+			if strings.Join(checks, ",") != strings.Join(tt.expectedChecks, ",") {
+				t.Errorf("Expected findings %v, got %+v", tt.expectedChecks, preflightErr.Findings)
+			}
+		})
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	exists := func(context.Context) (bool, error) { return true, nil }
+	const resource = "projects/test/topics/target"
+
+	tests := []struct {
+		name          string
+		granted       []string
+		err           error
+		expectedCheck string
+		expectError   bool
+	}{
+		{name: "granted", granted: []string{permissionPublish}},
+		{name: "not granted", expectedCheck: PreflightPermission},
+		{name: "no access", err: status.Error(codes.PermissionDenied, "denied"), expectedCheck: PreflightPermission},
+		{name: "missing", err: status.Error(codes.NotFound, "not found"), expectedCheck: PreflightExists},
+		{name: "emulator", err: status.Error(codes.Unimplemented, "unknown service")},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "try again"), expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPermissions := func(context.Context, []string) ([]string, error) { return tt.granted, tt.err }
+			f, err := checkAccess(context.Background(), resource, testPermissions, exists, permissionPublish, "writer@test.iam.gserviceaccount.com")
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			check := ""
+			if f != nil {
+				check = f.Check
+			}
+			if check != tt.expectedCheck {
+				t.Errorf("Expected finding %q, got %+v", tt.expectedCheck, f)
+			}
+		})
+	}
+
+	f, _ := checkAccess(context.Background(), resource, func(context.Context, []string) ([]string, error) { return nil, nil }, exists, permissionPublish, "writer@test.iam.gserviceaccount.com")
+	if f == nil || !strings.Contains(f.Message, "writer@test.iam.gserviceaccount.com is missing permission pubsub.topics.publish") {
+		t.Errorf("Expected the finding to name identity and permission, got %+v", f)
+	}
+}
+
+func TestHandler_PreflightFailure(t *testing.T) {
+	useTestServer(t, newShovelFixture(t, 0))
+
+	body, _ := json.Marshal(ShovelRequest{
+		NumMessages:        10,
+		SourceSubscription: "projects/test/subscriptions/missing",
+		TargetTopic:        "projects/test/topics/target",
+	})
+	rr := httptest.NewRecorder()
+	Handler(rr, httptest.NewRequest("POST", "/", bytes.NewBuffer(body)))
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status code %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	var response ShovelResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Findings) != 1 || response.Findings[0].Resource != "projects/test/subscriptions/missing" {
+		t.Errorf("Expected one finding for the missing subscription, got %+v", response.Findings)
+	}
+	if response.RequestID != "" {
+		t.Errorf("Expected no job to be started, got %s", response.RequestID)
+	}
+}
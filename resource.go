@@ -0,0 +1,94 @@
+package shovel
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Pub/Sub resource types as they appear in resource names
+const (
+	ResourceTopic        = "topics"
+	ResourceSubscription = "subscriptions"
+	ResourceSnapshot     = "snapshots"
+)
+
+var (
+	// projectIDPattern matches project IDs, optionally scoped to a domain as in
+	// "example.com:my-project". The 6 character minimum of real project IDs is
+	// not enforced so that emulator projects like "test" keep working.
+	projectIDPattern = regexp.MustCompile(`^([a-z0-9][a-z0-9.-]*:)?[a-z][a-z0-9-]{0,28}[a-z0-9]$`)
+	// resourceIDPattern matches topic, subscription and snapshot IDs
+	resourceIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.~+%-]{2,254}$`)
+)
+
+// ResourceName is a parsed topic, subscription or snapshot name
+type ResourceName struct {
+	Project string
+	Type    string // ResourceTopic, ResourceSubscription or ResourceSnapshot
+	ID      string
+}
+
+// String returns the full name, e.g. projects/P/topics/T
+func (n ResourceName) String() string {
+	return "projects/" + n.Project + "/" + n.Type + "/" + n.ID
+}
+
+// ParseTopic parses projects/P/topics/T or, with a default project
+// configured, the short form T
+func ParseTopic(name string) (ResourceName, error) {
+	return parseResourceName(name, ResourceTopic)
+}
+
+// ParseSubscription parses projects/P/subscriptions/S or, with a default
+// project configured, the short form S
+func ParseSubscription(name string) (ResourceName, error) {
+	return parseResourceName(name, ResourceSubscription)
+}
+
+// ParseSnapshot parses projects/P/snapshots/S or, with a default project
+// configured, the short form S
+func ParseSnapshot(name string) (ResourceName, error) {
+	return parseResourceName(name, ResourceSnapshot)
+}
+
+// parseResourceName parses name as a resource of the given type
+func parseResourceName(name, resourceType string) (ResourceName, error) {
+	kind := strings.TrimSuffix(resourceType, "s")
+	format := "projects/PROJECT_ID/" + resourceType + "/" + strings.ToUpper(kind) + "_ID"
+
+	var n ResourceName
+	if !strings.Contains(name, "/") {
+		project := defaultProject()
+		if project == "" {
+			return n, fmt.Errorf("%s %q must be in format %s, or set GOOGLE_CLOUD_PROJECT to use short names", kind, name, format)
+		}
+		n = ResourceName{Project: project, Type: resourceType, ID: name}
+	} else {
+		parts := strings.Split(name, "/")
+		if len(parts) != 4 || parts[0] != "projects" {
+			return n, fmt.Errorf("%s %q must be in format %s", kind, name, format)
+		}
+		n = ResourceName{Project: parts[1], Type: parts[2], ID: parts[3]}
+	}
+
+	if n.Type != resourceType {
+		if n.Type == ResourceTopic || n.Type == ResourceSubscription {
+			return n, fmt.Errorf("%q is a %s, not a %s", name, strings.TrimSuffix(n.Type, "s"), kind)
+		}
+		return n, fmt.Errorf("%s %q must be in format %s", kind, name, format)
+	}
+	if !projectIDPattern.MatchString(n.Project) {
+		return n, fmt.Errorf("%s %q has an invalid project ID %q", kind, name, n.Project)
+	}
+	if !resourceIDPattern.MatchString(n.ID) || strings.HasPrefix(n.ID, "goog") {
+		return n, fmt.Errorf("%s %q has an invalid ID %q: it must have 3 to 255 letters, digits or -_.~+%%, start with a letter and not start with goog", kind, name, n.ID)
+	}
+	return n, nil
+}
+
+// defaultProject returns the project short resource names belong to
+func defaultProject() string {
+	return os.Getenv("GOOGLE_CLOUD_PROJECT")
+}
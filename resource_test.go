@@ -0,0 +1,117 @@
+package shovel
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		input          string
+		defaultProject string
+		expected       ResourceName
+		expectedError  string
+	}{
+		{
+			input:    "projects/my-project/topics/my-topic",
+			expected: ResourceName{Project: "my-project", Type: ResourceTopic, ID: "my-topic"},
+		},
+		{
+			input:    "projects/example.com:my-project/topics/orders.v1~eu+%41",
+			expected: ResourceName{Project: "example.com:my-project", Type: ResourceTopic, ID: "orders.v1~eu+%41"},
+		},
+		{
+			input:          "my-topic",
+			defaultProject: "default-project",
+			expected:       ResourceName{Project: "default-project", Type: ResourceTopic, ID: "my-topic"},
+		},
+		{input: "my-topic", expectedError: "GOOGLE_CLOUD_PROJECT"},
+		{input: "invalid-format/x", expectedError: "must be in format projects/PROJECT_ID/topics/TOPIC_ID"},
+		{input: "projects/my-project/topics/my-topic/extra", expectedError: "must be in format"},
+		{input: "projects//topics/my-topic", expectedError: "invalid project ID"},
+		{input: "projects/My_Project/topics/my-topic", expectedError: "invalid project ID"},
+		{input: "projects/my-project-/topics/my-topic", expectedError: "invalid project ID"},
+		{input: "projects/my-project/snapshots/my-topic", expectedError: "must be in format"},
+		{input: "projects/my-project/subscriptions/my-sub", expectedError: "is a subscription, not a topic"},
+		{input: "projects/my-project/topics/ab", expectedError: "invalid ID"},
+		{input: "projects/my-project/topics/1topic", expectedError: "invalid ID"},
+		{input: "projects/my-project/topics/goog-topic", expectedError: "invalid ID"},
+		{input: "projects/my-project/topics/my topic", expectedError: "invalid ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			t.Setenv("GOOGLE_CLOUD_PROJECT", tt.defaultProject)
+			name, err := ParseTopic(tt.input)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("Expected error containing %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if name != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, name)
+			}
+		})
+	}
+}
+
+func TestParseSubscription(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "default-project")
+
+	name, err := ParseSubscription("projects/my-project/subscriptions/my-sub")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if name.String() != "projects/my-project/subscriptions/my-sub" {
+		t.Errorf("Expected the full name to round-trip, got %s", name)
+	}
+
+	name, err = ParseSubscription("my-sub")
+	if err != nil || name.String() != "projects/default-project/subscriptions/my-sub" {
+		t.Errorf("Expected short name in the default project, got %s, %v", name, err)
+	}
+
+	if _, err := ParseSubscription("projects/my-project/topics/my-topic"); err == nil || !strings.Contains(err.Error(), "is a topic, not a subscription") {
+		t.Errorf("Expected a topic to be rejected as subscription, got %v", err)
+	}
+}
+
+func TestParseSnapshot(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "default-project")
+
+	name, err := ParseSnapshot("before-replay")
+	if err != nil || name.String() != "projects/default-project/snapshots/before-replay" {
+		t.Errorf("Expected short name in the default project, got %s, %v", name, err)
+	}
+
+	if _, err := ParseSnapshot("projects/my-project/subscriptions/my-sub"); err == nil || !strings.Contains(err.Error(), "is a subscription, not a snapshot") {
+		t.Errorf("Expected a subscription to be rejected as snapshot, got %v", err)
+	}
+}
+
+func TestValidateRequest_NormalizesResourceNames(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "default-project")
+	req := ShovelRequest{
+		NumMessages:        1,
+		SourceSubscription: "source-sub",
+		TargetTopic:        "projects/other-project/topics/target",
+		NotifyTopic:        "events",
+	}
+	if err := validateRequest(context.Background(), &req); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if req.SourceSubscription != "projects/default-project/subscriptions/source-sub" {
+		t.Errorf("Expected full source subscription, got %s", req.SourceSubscription)
+	}
+	if req.TargetTopic != "projects/other-project/topics/target" {
+		t.Errorf("Expected target topic to stay unchanged, got %s", req.TargetTopic)
+	}
+	if req.NotifyTopic != "projects/default-project/topics/events" {
+		t.Errorf("Expected full notify topic, got %s", req.NotifyTopic)
+	}
+}
@@ -0,0 +1,108 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validateSeek checks the seek options of req. seekToSnapshot is replaced by
+// its full name.
+func validateSeek(req *ShovelRequest) error {
+	if req.SeekToTime == "" && req.SeekToSnapshot == "" {
+		if req.SnapshotBeforeSeek {
+			return fmt.Errorf("snapshotBeforeSeek requires seekToTime or seekToSnapshot")
+		}
+		return nil
+	}
+	if req.SeekToTime != "" && req.SeekToSnapshot != "" {
+		return fmt.Errorf("cannot specify both seekToTime and seekToSnapshot")
+	}
+	if req.SourceKafka != nil {
+		return fmt.Errorf("seeking is only supported for sourceSubscription")
+	}
+	if req.SeekToTime != "" {
+		t, err := time.Parse(time.RFC3339, req.SeekToTime)
+		if err != nil {
+			return fmt.Errorf("invalid seekToTime: must be an RFC 3339 time, e.g. 2024-01-01T10:00:00Z")
+		}
+		// Seeking into the future acknowledges the whole backlog
+		if t.After(time.Now()) {
+			return fmt.Errorf("seekToTime must not be in the future")
+		}
+		return nil
+	}
+	if err := normalizeResourceName("seekToSnapshot", &req.SeekToSnapshot, ParseSnapshot); err != nil {
+		return err
+	}
+	snapshot, _ := ParseSnapshot(req.SeekToSnapshot)
+	source, err := ParseSubscription(req.SourceSubscription)
+	if err != nil {
+		return err
+	}
+	if snapshot.Project != source.Project {
+		return fmt.Errorf("seekToSnapshot must be in project %s of sourceSubscription", source.Project)
+	}
+	return nil
+}
+
+// seekSource seeks the source subscription as requested, optionally taking a
+// snapshot first so that the seek can be undone. Resumed jobs do not seek
+// again, nor take another snapshot.
+func (j *Job) seekSource(ctx context.Context, client *pubsub.Client, sub *pubsub.Subscription) error {
+	req := j.Request
+	if req.SeekToTime == "" && req.SeekToSnapshot == "" {
+		return nil
+	}
+	j.mu.Lock()
+	seekedAt, snapshotted := j.seekedAt, j.snapshot != ""
+	j.mu.Unlock()
+	if !seekedAt.IsZero() {
+		j.log(ctx, slog.LevelInfo, "Skipping seek of resumed request", "seekedAt", seekedAt)
+		return nil
+	}
+
+	if req.SnapshotBeforeSeek && !snapshotted {
+		// A run that stopped between snapshot and seek left the snapshot behind
+		source, _ := ParseSubscription(req.SourceSubscription)
+		snapshot := ResourceName{Project: source.Project, Type: ResourceSnapshot, ID: j.ID}.String()
+		config, err := sub.CreateSnapshot(ctx, j.ID)
+		switch {
+		case status.Code(err) == codes.AlreadyExists:
+			j.log(ctx, slog.LevelInfo, "Using snapshot of earlier attempt", "snapshot", snapshot)
+		case err != nil:
+			return fmt.Errorf("failed to snapshot source subscription: %v", err)
+		default:
+			j.log(ctx, slog.LevelInfo, "Created snapshot before seeking", "snapshot", snapshot, "expiresAt", config.Expiration)
+		}
+		j.mu.Lock()
+		j.snapshot = snapshot
+		j.mu.Unlock()
+		j.persist()
+	}
+
+	var err error
+	if req.SeekToTime != "" {
+		t, _ := time.Parse(time.RFC3339, req.SeekToTime)
+		err = sub.SeekToTime(ctx, t)
+	} else {
+		snapshot, _ := ParseSnapshot(req.SeekToSnapshot)
+		err = sub.SeekToSnapshot(ctx, client.Snapshot(snapshot.ID))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to seek source subscription: %v", err)
+	}
+
+	j.mu.Lock()
+	j.seekedAt = time.Now()
+	j.mu.Unlock()
+	j.log(ctx, slog.LevelInfo, "Seeked source subscription", "seekToTime", req.SeekToTime, "seekToSnapshot", req.SeekToSnapshot)
+	// Keep the seek and snapshot on record in case the instance goes down
+	j.persist()
+	return nil
+}
@@ -0,0 +1,203 @@
+package shovel
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// seekRecorder answers the snapshot and seek calls the fake server does not
+// support and records them
+type seekRecorder struct {
+	seekCode     codes.Code // Fail seeks with this code
+	snapshotCode codes.Code // Fail snapshots with this code
+
+	mu        sync.Mutex
+	snapshots []*pubsubpb.CreateSnapshotRequest
+	seeks     []*pubsubpb.SeekRequest
+}
+
+func (r *seekRecorder) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithUnaryInterceptor(r.intercept)}
+}
+
+func (r *seekRecorder) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case strings.HasSuffix(method, "/CreateSnapshot"):
+		in := req.(*pubsubpb.CreateSnapshotRequest)
+		r.snapshots = append(r.snapshots, in)
+		if r.snapshotCode != codes.OK {
+			return status.Error(r.snapshotCode, "injected snapshot failure")
+		}
+		out := reply.(*pubsubpb.Snapshot)
+		out.Name = in.Name
+		out.Topic = "projects/test/topics/source"
+		out.ExpireTime = timestamppb.New(time.Now().Add(7 * 24 * time.Hour))
+		return nil
+	case strings.HasSuffix(method, "/Seek"):
+		r.seeks = append(r.seeks, req.(*pubsubpb.SeekRequest))
+		if r.seekCode != codes.OK {
+			return status.Error(r.seekCode, "injected seek failure")
+		}
+		return nil
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func TestValidateSeek(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "default-project")
+	source := "projects/test/subscriptions/source-sub"
+
+	tests := []struct {
+		name             string
+		req              ShovelRequest
+		expectedSnapshot string
+		expectError      bool
+	}{
+		{name: "no seek", req: ShovelRequest{SourceSubscription: source}},
+		{name: "time", req: ShovelRequest{SourceSubscription: source, SeekToTime: "2024-01-01T10:00:00Z", SnapshotBeforeSeek: true}},
+		{name: "snapshot", req: ShovelRequest{SourceSubscription: source, SeekToSnapshot: "projects/test/snapshots/before-replay"}, expectedSnapshot: "projects/test/snapshots/before-replay"},
+		{name: "short snapshot name", req: ShovelRequest{SourceSubscription: "projects/default-project/subscriptions/source-sub", SeekToSnapshot: "before-replay"}, expectedSnapshot: "projects/default-project/snapshots/before-replay"},
+		{name: "snapshot without seek", req: ShovelRequest{SourceSubscription: source, SnapshotBeforeSeek: true}, expectError: true},
+		{name: "time and snapshot", req: ShovelRequest{SourceSubscription: source, SeekToTime: "2024-01-01T10:00:00Z", SeekToSnapshot: "projects/test/snapshots/before-replay"}, expectError: true},
+		{name: "invalid time", req: ShovelRequest{SourceSubscription: source, SeekToTime: "yesterday"}, expectError: true},
+		{name: "future time", req: ShovelRequest{SourceSubscription: source, SeekToTime: time.Now().Add(time.Hour).Format(time.RFC3339)}, expectError: true},
+		{name: "invalid snapshot", req: ShovelRequest{SourceSubscription: source, SeekToSnapshot: "projects/test/topics/source"}, expectError: true},
+		{name: "snapshot in other project", req: ShovelRequest{SourceSubscription: source, SeekToSnapshot: "projects/other/snapshots/before-replay"}, expectError: true},
+		{name: "kafka source", req: ShovelRequest{SourceKafka: &KafkaSource{Topic: "orders"}, SeekToTime: "2024-01-01T10:00:00Z"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := validateSeek(&req)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if tt.expectedSnapshot != "" && req.SeekToSnapshot != tt.expectedSnapshot {
+				t.Errorf("Expected snapshot %s, got %s", tt.expectedSnapshot, req.SeekToSnapshot)
+			}
+		})
+	}
+}
+
+func TestJob_SeekSource(t *testing.T) {
+	seekTime := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	source := "projects/test/subscriptions/source-sub"
+
+	tests := []struct {
+		name              string
+		req               ShovelRequest
+		resumed           bool
+		snapshotted       bool // Resumed after the snapshot, but before the seek
+		seekCode          codes.Code
+		snapshotCode      codes.Code
+		expectedSnapshots int
+		expectedSeeks     int
+		expectError       bool
+	}{
+		{name: "no seek", req: ShovelRequest{}},
+		{name: "time with snapshot", req: ShovelRequest{SeekToTime: seekTime.Format(time.RFC3339), SnapshotBeforeSeek: true}, expectedSnapshots: 1, expectedSeeks: 1},
+		{name: "snapshot", req: ShovelRequest{SeekToSnapshot: "projects/test/snapshots/before-replay"}, expectedSeeks: 1},
+		{name: "seek fails", req: ShovelRequest{SeekToTime: seekTime.Format(time.RFC3339), SnapshotBeforeSeek: true}, seekCode: codes.PermissionDenied, expectedSnapshots: 1, expectedSeeks: 1, expectError: true},
+		{name: "resumed", req: ShovelRequest{SeekToTime: seekTime.Format(time.RFC3339), SnapshotBeforeSeek: true}, resumed: true},
+		{name: "resumed after snapshot", req: ShovelRequest{SeekToTime: seekTime.Format(time.RFC3339), SnapshotBeforeSeek: true}, snapshotted: true, expectedSeeks: 1},
+		{name: "snapshot of earlier attempt", req: ShovelRequest{SeekToTime: seekTime.Format(time.RFC3339), SnapshotBeforeSeek: true}, snapshotCode: codes.AlreadyExists, expectedSnapshots: 1, expectedSeeks: 1},
+		{name: "snapshot fails", req: ShovelRequest{SeekToTime: seekTime.Format(time.RFC3339), SnapshotBeforeSeek: true}, snapshotCode: codes.PermissionDenied, expectedSnapshots: 1, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &seekRecorder{seekCode: tt.seekCode, snapshotCode: tt.snapshotCode}
+			useTestServer(t, newShovelFixture(t, 0), recorder.dialOptions()...)
+			ctx := context.Background()
+			client, err := pubsubClient(ctx, "test", "")
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+
+			req := tt.req
+			req.SourceSubscription = source
+			job := newJob("shovel-seek-test", req)
+			if tt.resumed {
+				job.seekedAt = time.Now()
+			}
+			if tt.snapshotted {
+				job.snapshot = "projects/test/snapshots/shovel-seek-test"
+			}
+			err = job.seekSource(ctx, client, client.Subscription("source-sub"))
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if len(recorder.snapshots) != tt.expectedSnapshots || len(recorder.seeks) != tt.expectedSeeks {
+				t.Fatalf("Expected %d snapshots and %d seeks, got %d and %d", tt.expectedSnapshots, tt.expectedSeeks, len(recorder.snapshots), len(recorder.seeks))
+			}
+
+			status := job.Status()
+			if tt.expectedSnapshots > 0 {
+				if got := recorder.snapshots[0]; got.Name != "projects/test/snapshots/shovel-seek-test" || got.Subscription != source {
+					t.Errorf("Expected a snapshot named after the job, got %s of %s", got.Name, got.Subscription)
+				}
+			}
+			if tt.expectedSnapshots > 0 && !tt.expectError || tt.snapshotted {
+				if status.Snapshot != "projects/test/snapshots/shovel-seek-test" {
+					t.Errorf("Expected the snapshot in the job result, got %q", status.Snapshot)
+				}
+			}
+			if tt.expectedSeeks > 0 {
+				seek := recorder.seeks[0]
+				if req.SeekToTime != "" && !seek.GetTime().AsTime().Equal(seekTime) {
+					t.Errorf("Expected seek to %v, got %v", seekTime, seek.GetTime().AsTime())
+				}
+				if req.SeekToSnapshot != "" && seek.GetSnapshot() != req.SeekToSnapshot {
+					t.Errorf("Expected seek to %s, got %s", req.SeekToSnapshot, seek.GetSnapshot())
+				}
+			}
+			if seeked := status.SeekedAt != nil; seeked != (tt.expectedSeeks > 0 && !tt.expectError || tt.resumed) {
+				t.Errorf("Expected seekedAt only after a successful seek, got %v", status.SeekedAt)
+			}
+		})
+	}
+}
+
+func TestResumeJob_KeepsSeek(t *testing.T) {
+	job := newJob("shovel-seek-test", ShovelRequest{AllMessages: true, SeekToTime: "2024-01-01T10:00:00Z", SnapshotBeforeSeek: true})
+	job.snapshot = "projects/test/snapshots/shovel-seek-test"
+	job.seekedAt = time.Now()
+	job.interrupt()
+	job.finish(nil)
+
+	resumed := resumeJob(job.Record())
+	status := resumed.Status()
+	if status.SeekedAt == nil || status.Snapshot != job.snapshot {
+		t.Errorf("Expected the resumed job to remember its seek, got %v and %q", status.SeekedAt, status.Snapshot)
+	}
+}
+
+func TestIntegration_Seek(t *testing.T) {
+	recorder := &seekRecorder{}
+	e := newIntegrationEnv(t, recorder.dialOptions()...)
+	e.publishN(10)
+
+	seekTime := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	status := e.run(ShovelRequest{NumMessages: 5, SeekToTime: seekTime.Format(time.RFC3339), SnapshotBeforeSeek: true})
+	if status.ProcessedCount != 5 {
+		t.Errorf("Expected 5 processed messages, got %d", status.ProcessedCount)
+	}
+	if len(recorder.seeks) != 1 || status.SeekedAt == nil {
+		t.Errorf("Expected the source to be seeked once before receiving, got %d seeks", len(recorder.seeks))
+	}
+	if !strings.HasSuffix(status.Snapshot, "/snapshots/"+status.ID) {
+		t.Errorf("Expected the snapshot in the job result, got %q", status.Snapshot)
+	}
+}
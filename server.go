@@ -0,0 +1,32 @@
+package shovel
+
+import "net/http"
+
+// NewServeMux returns the routes of the standalone shovel server:
+//
+//	POST /jobs              create a job (same payload as Handler)
+//	GET  /jobs              list jobs
+//	GET  /jobs/{id}         job status
+//	POST /jobs/{id}/cancel  cancel a job
+//	GET  /jobs/{id}/events  stream job progress (SSE or NDJSON)
+//	GET  /healthz           health of the running jobs
+//	GET  /metrics           Prometheus metrics
+//
+// POST / is kept as an alias for POST /jobs so existing clients of the
+// Cloud Function keep working. The job routes answer CORS preflight requests.
+func NewServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", Handler)
+	mux.HandleFunc("/jobs", Handler)
+	mux.HandleFunc("GET /jobs", ListHandler)
+	mux.HandleFunc("GET /jobs/{id}", StatusHandler)
+	mux.HandleFunc("POST /jobs/{id}/cancel", CancelHandler)
+	mux.HandleFunc("GET /jobs/{id}/events", StreamHandler)
+	mux.HandleFunc("GET /healthz", HealthHandler)
+	mux.HandleFunc("GET /metrics", MetricsHandler)
+	mux.HandleFunc("OPTIONS /jobs", PreflightHandler("GET", "POST"))
+	mux.HandleFunc("OPTIONS /jobs/{id}", PreflightHandler("GET"))
+	mux.HandleFunc("OPTIONS /jobs/{id}/cancel", PreflightHandler("POST"))
+	mux.HandleFunc("OPTIONS /jobs/{id}/events", PreflightHandler("GET"))
+	return mux
+}
@@ -0,0 +1,107 @@
+package shovel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewServeMux_Routes(t *testing.T) {
+	job := newJob("shovel-server-test", ShovelRequest{TargetTopic: "projects/test/topics/target"})
+	jobs.add(job)
+	defer job.finish(nil)
+
+	srv := httptest.NewServer(NewServeMux())
+	defer srv.Close()
+
+	tests := []struct {
+		method       string
+		path         string
+		expectedCode int
+	}{
+		{method: "GET", path: "/jobs", expectedCode: http.StatusOK},
+		{method: "GET", path: "/jobs/" + job.ID, expectedCode: http.StatusOK},
+		{method: "GET", path: "/jobs/unknown", expectedCode: http.StatusNotFound},
+		{method: "POST", path: "/jobs/unknown/cancel", expectedCode: http.StatusNotFound},
+		{method: "PUT", path: "/jobs", expectedCode: http.StatusMethodNotAllowed},
+		{method: "OPTIONS", path: "/jobs", expectedCode: http.StatusNoContent},
+		{method: "OPTIONS", path: "/jobs/" + job.ID, expectedCode: http.StatusNoContent},
+		{method: "OPTIONS", path: "/jobs/" + job.ID + "/cancel", expectedCode: http.StatusNoContent},
+		{method: "GET", path: "/healthz", expectedCode: http.StatusOK},
+		{method: "GET", path: "/metrics", expectedCode: http.StatusOK},
+		{method: "GET", path: "/unknown", expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expectedCode {
+				t.Errorf("Expected status code %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestNewServeMux_CancelByPath(t *testing.T) {
+	job := newJob("shovel-server-cancel-test", ShovelRequest{Mode: ModeContinuous})
+	jobs.add(job)
+
+	srv := httptest.NewServer(NewServeMux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/jobs/"+job.ID+"/cancel", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	var response ShovelResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.RequestID != job.ID {
+		t.Errorf("Expected request ID %s, got %s", job.ID, response.RequestID)
+	}
+	job.finish(nil)
+	if state := job.Status().State; state != JobStateCancelled {
+		t.Errorf("Expected state %s, got %s", JobStateCancelled, state)
+	}
+}
+
+func TestDrainJobs(t *testing.T) {
+	continuous := newJob("shovel-drain-continuous", ShovelRequest{Mode: ModeContinuous})
+	oneShot := newJob("shovel-drain-oneshot", ShovelRequest{NumMessages: 10})
+	jobs.add(continuous)
+	jobs.add(oneShot)
+
+	// Simulate the job goroutines reacting to cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	continuous.cancel = cancel
+	go func() {
+		<-ctx.Done()
+		continuous.finish(nil)
+	}()
+	time.AfterFunc(50*time.Millisecond, func() { oneShot.finish(nil) })
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer drainCancel()
+	if err := DrainJobs(drainCtx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if state := continuous.Status().State; state != JobStateInterrupted {
+		t.Errorf("Expected continuous job to be interrupted, got %s", state)
+	}
+	if state := oneShot.Status().State; state != JobStateCompleted {
+		t.Errorf("Expected one-shot job to complete, got %s", state)
+	}
+}
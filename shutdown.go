@@ -0,0 +1,55 @@
+package shovel
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ShutdownTimeout is how long in-flight publishes may take after SIGTERM.
+// Cloud Functions and Cloud Run kill the instance 10 seconds after SIGTERM.
+const ShutdownTimeout = 8 * time.Second
+
+var shutdownHookOnce sync.Once
+
+func init() {
+	// The Cloud Functions runtime sets FUNCTION_TARGET and owns main, so the
+	// hook has to be installed from here
+	if os.Getenv("FUNCTION_TARGET") != "" {
+		ShutdownOnSignal(ShutdownTimeout)
+	}
+}
+
+// ShutdownOnSignal shuts down all jobs when the process receives SIGTERM or
+// SIGINT and exits once they recorded their final state and the notifications
+// and audit records are sent
+func ShutdownOnSignal(timeout time.Duration) {
+	shutdownHookOnce.Do(func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			s := <-sig
+			logger.Info("Received signal, shutting down", "signal", s.String())
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := Shutdown(ctx); err != nil {
+				logger.Warn("Shutdown incomplete", "error", err)
+			}
+			for _, job := range jobs.list() {
+				job.log(ctx, slog.LevelInfo, "Request final state", "state", job.Status().State)
+			}
+			if err := FlushNotifications(ctx); err != nil {
+				logger.Warn("Notifications not delivered", "error", err)
+			}
+			if err := FlushAudit(ctx); err != nil {
+				logger.Warn("Audit records not written", "error", err)
+			}
+			os.Exit(0)
+		}()
+	})
+}
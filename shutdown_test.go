@@ -0,0 +1,163 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// useTestServer makes processShovelRequest connect to srv for every project
+func useTestServer(t *testing.T, srv *pstest.Server, opts ...grpc.DialOption) {
+	t.Helper()
+	useServerAddr(t, srv.Addr, opts...)
+}
+
+// useServerAddr points all Pub/Sub clients at the insecure server at addr,
+// either a fake server or the emulator
+func useServerAddr(t *testing.T, addr string, opts ...grpc.DialOption) {
+	t.Helper()
+	original := newPubsubClient
+	// Clients of earlier tests point to their own fake server
+	CloseClients()
+	// Credentials are ignored, the fake server accepts every identity
+	newPubsubClient = func(ctx context.Context, projectID string, _ ...option.ClientOption) (*pubsub.Client, error) {
+		conn, err := grpc.Dial(addr, append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)...)
+		if err != nil {
+			return nil, err
+		}
+		return pubsub.NewClient(ctx, projectID, option.WithGRPCConn(conn))
+	}
+	t.Cleanup(func() {
+		CloseClients()
+		newPubsubClient = original
+	})
+}
+
+// delayPublish delays every Publish RPC by d unless its context ends first
+func delayPublish(d time.Duration) grpc.DialOption {
+	return grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if strings.HasSuffix(method, "/Publish") {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+// newShovelFixture creates source and target topics plus a source
+// subscription holding n messages
+func newShovelFixture(t *testing.T, n int) *pstest.Server {
+	t.Helper()
+	ctx := context.Background()
+	srv, client := newTestClient(t)
+	source, err := client.CreateTopic(ctx, "source")
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	if _, err := client.CreateTopic(ctx, "target"); err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	if _, err := client.CreateSubscription(ctx, "source-sub", pubsub.SubscriptionConfig{Topic: source}); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		srv.Publish("projects/test/topics/source", []byte(fmt.Sprintf("message-%d", i)), nil)
+	}
+	return srv
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// nackedCount returns the number of source messages that were nacked
+func nackedCount(srv *pstest.Server) int {
+	nacked := 0
+	for _, m := range srv.Messages() {
+		for _, modack := range m.Modacks {
+			if modack.AckDeadline == 0 {
+				nacked++
+				break
+			}
+		}
+	}
+	return nacked
+}
+
+func TestShutdown_NacksUnpublishedMessages(t *testing.T) {
+	srv := newShovelFixture(t, 3)
+	// Publishes hang for longer than the shutdown takes
+	useTestServer(t, srv, delayPublish(10*time.Second))
+
+	job := newJob("shovel-shutdown-test", ShovelRequest{
+		Mode:               ModeContinuous,
+		SourceSubscription: "projects/test/subscriptions/source-sub",
+		TargetTopic:        "projects/test/topics/target",
+	})
+	jobs.add(job)
+	job.start(context.Background())
+	waitFor(t, "messages to be accepted", func() bool { return job.acceptedCount() == 3 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := Shutdown(ctx); err == nil {
+		t.Error("Expected shutdown to report jobs that did not finish in time")
+	}
+
+	// The process exits right after Shutdown, the final state must be stored by then
+	status := job.Status()
+	if status.State != JobStateInterrupted {
+		t.Errorf("Expected state %s, got %s", JobStateInterrupted, status.State)
+	}
+	if status.NackedCount != 3 || status.ProcessedCount != 0 {
+		t.Errorf("Expected 3 nacked and 0 processed messages, got %+v", status)
+	}
+	if acked := ackedCount(srv); acked != 0 {
+		t.Errorf("Expected no source message to be acked, got %d", acked)
+	}
+	waitFor(t, "nacks to reach the server", func() bool { return nackedCount(srv) == 3 })
+}
+
+func TestCancel_FinishesInFlightPublishes(t *testing.T) {
+	srv := newShovelFixture(t, 3)
+	useTestServer(t, srv, delayPublish(300*time.Millisecond))
+
+	job := newJob("shovel-cancel-inflight-test", ShovelRequest{
+		Mode:               ModeContinuous,
+		SourceSubscription: "projects/test/subscriptions/source-sub",
+		TargetTopic:        "projects/test/topics/target",
+	})
+	job.start(context.Background())
+	waitFor(t, "messages to be accepted", func() bool { return job.acceptedCount() == 3 })
+
+	job.Cancel()
+	<-job.Done()
+
+	status := job.Status()
+	if status.State != JobStateCancelled {
+		t.Errorf("Expected state %s, got %s", JobStateCancelled, status.State)
+	}
+	if status.ProcessedCount != 3 || status.NackedCount != 0 || status.FailedCount != 0 {
+		t.Errorf("Expected all in-flight messages to be published and acked, got %+v", status)
+	}
+	waitFor(t, "acks to reach the server", func() bool { return ackedCount(srv) == 3 })
+}
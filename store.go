@@ -0,0 +1,299 @@
+package shovel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned by a JobStore for unknown job IDs
+var ErrJobNotFound = errors.New("job not found")
+
+// StateTransition records a change of a job's state
+type StateTransition struct {
+	State  JobState  `json:"state"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// JobRecord is the persisted form of a job
+type JobRecord struct {
+	ID          string            `json:"id"`
+	Principal   Principal         `json:"principal"`
+	Request     ShovelRequest     `json:"request"`
+	Status      JobStatus         `json:"status"`
+	Transitions []StateTransition `json:"transitions"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+// JobStore persists job records so they survive instance restarts
+type JobStore interface {
+	// Save creates or replaces the record with the same ID
+	Save(ctx context.Context, record JobRecord) error
+	// Get returns the record with the given ID or ErrJobNotFound
+	Get(ctx context.Context, id string) (JobRecord, error)
+	// List returns the records matching filter ordered by start time
+	List(ctx context.Context, filter JobFilter) ([]JobRecord, error)
+}
+
+// jobStore is the store used for all jobs of this process
+var jobStore JobStore = NewMemoryJobStore()
+
+func init() {
+	store, err := NewJobStore(os.Getenv("SHOVEL_JOB_STORE"))
+	if err != nil {
+		logger.Error("Failed to configure job store, falling back to memory", "error", err)
+		return
+	}
+	jobStore = store
+}
+
+// SetJobStore replaces the job store. It must be called before any job starts.
+func SetJobStore(store JobStore) {
+	jobStore = store
+}
+
+// NewJobStore creates a store from a spec like "memory" or "file:/var/lib/shovel"
+func NewJobStore(spec string) (JobStore, error) {
+	switch {
+	case spec == "" || spec == "memory":
+		return NewMemoryJobStore(), nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileJobStore(strings.TrimPrefix(spec, "file:"))
+	default:
+		return nil, fmt.Errorf("unknown job store %q", spec)
+	}
+}
+
+// MemoryJobStore keeps job records in memory
+type MemoryJobStore struct {
+	mu        sync.Mutex
+	records   map[string]JobRecord
+	dedupKeys map[string]map[string]time.Time // Expiry of dedup keys by scope
+}
+
+// NewMemoryJobStore creates an empty in-memory store
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{records: make(map[string]JobRecord)}
+}
+
+// Save stores the record
+func (s *MemoryJobStore) Save(_ context.Context, record JobRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ID] = record
+	return nil
+}
+
+// Get returns the record with the given ID
+func (s *MemoryJobStore) Get(_ context.Context, id string) (JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return JobRecord{}, ErrJobNotFound
+	}
+	return record, nil
+}
+
+// List returns the records matching filter ordered by start time
+func (s *MemoryJobStore) List(_ context.Context, filter JobFilter) ([]JobRecord, error) {
+	s.mu.Lock()
+	records := make([]JobRecord, 0, len(s.records))
+	for _, record := range s.records {
+		if filter.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	s.mu.Unlock()
+	sortRecords(records)
+	return records, nil
+}
+
+// FileJobStore keeps one JSON file per job in a directory
+type FileJobStore struct {
+	dir        string
+	mu         sync.Mutex
+	dedupLines map[string]int // Lines in the dedup file of each scope, once known
+}
+
+// NewFileJobStore creates a store in dir, creating the directory if needed
+func NewFileJobStore(dir string) (*FileJobStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("job store directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory: %v", err)
+	}
+	return &FileJobStore{dir: dir}, nil
+}
+
+// Save writes the record atomically by renaming a temporary file
+func (s *FileJobStore) Save(_ context.Context, record JobRecord) error {
+	path, err := s.path(record.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job record: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write job record: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write job record: %v", err)
+	}
+	return nil
+}
+
+// Get reads the record with the given ID
+func (s *FileJobStore) Get(_ context.Context, id string) (JobRecord, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return JobRecord{}, ErrJobNotFound
+	}
+	return readJobRecord(path)
+}
+
+// List reads the records matching filter ordered by start time
+func (s *FileJobStore) List(_ context.Context, filter JobFilter) ([]JobRecord, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list job records: %v", err)
+	}
+	records := make([]JobRecord, 0, len(paths))
+	for _, path := range paths {
+		record, err := readJobRecord(path)
+		if err != nil {
+			logger.Warn("Skipping unreadable job record", "path", path, "error", err)
+			continue
+		}
+		if filter.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+// path returns the file for a job ID, rejecting IDs that would escape the directory
+func (s *FileJobStore) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid job ID %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// readJobRecord decodes a single record file
+func readJobRecord(path string) (JobRecord, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return JobRecord{}, ErrJobNotFound
+	}
+	if err != nil {
+		return JobRecord{}, fmt.Errorf("failed to read job record: %v", err)
+	}
+	var record JobRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return JobRecord{}, fmt.Errorf("failed to decode job record: %v", err)
+	}
+	return record, nil
+}
+
+// sortRecords orders records by start time
+func sortRecords(records []JobRecord) {
+	sort.Slice(records, func(i, k int) bool {
+		return records[i].Status.StartedAt.Before(records[k].Status.StartedAt)
+	})
+}
+
+// Record returns the persisted form of the job
+func (j *Job) Record() JobRecord {
+	status := j.Status()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return JobRecord{
+		ID:          j.ID,
+		Principal:   j.Principal,
+		Request:     j.Request,
+		Status:      status,
+		Transitions: append([]StateTransition(nil), j.transitions...),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+// persist saves the current job record, logging failures
+func (j *Job) persist() error {
+	err := jobStore.Save(context.Background(), j.Record())
+	if err != nil {
+		j.log(context.Background(), slog.LevelError, "Failed to persist request", "error", err)
+	}
+	return err
+}
+
+// resumable reports whether a stored job was interrupted and can be restarted.
+// Jobs still marked running were interrupted by a crash of their instance.
+func (r JobRecord) resumable() bool {
+	if r.Status.State != JobStateRunning && r.Status.State != JobStateInterrupted {
+		return false
+	}
+	return r.Request.Mode == ModeContinuous || r.Request.AllMessages
+}
+
+// ResumeJobs restarts the continuous and drain (allMessages) jobs that the
+// store records as interrupted. Counters continue from their stored values.
+// It returns the IDs of the resumed jobs.
+func ResumeJobs(ctx context.Context) ([]string, error) {
+	records, err := jobStore.List(ctx, JobFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+
+	var resumed []string
+	for _, record := range records {
+		if !record.resumable() || jobs.get(record.ID) != nil {
+			continue
+		}
+		job := resumeJob(record)
+		jobs.put(job)
+		job.persist()
+		job.audit(AuditJobResumed)
+		job.start(context.Background())
+		job.log(ctx, slog.LevelInfo, "Resumed request", "previousState", record.Status.State)
+		resumed = append(resumed, job.ID)
+	}
+	return resumed, nil
+}
+
+// resumeJob recreates a job from its record
+func resumeJob(record JobRecord) *Job {
+	job := newJob(record.ID, record.Request)
+	job.Principal = record.Principal
+	job.startedAt = record.Status.StartedAt
+	job.accepted = record.Status.ProcessedCount + record.Status.FailedCount + record.Status.AckFailedCount
+	job.processed = record.Status.ProcessedCount
+	job.failed = record.Status.FailedCount
+	job.errorCounts = record.Status.ErrorCounts
+	job.duplicates = record.Status.DuplicateCount
+	job.ackFailed = record.Status.AckFailedCount
+	job.ackErrorCounts = record.Status.AckErrorCounts
+	job.snapshot = record.Status.Snapshot
+	if record.Status.SeekedAt != nil {
+		job.seekedAt = *record.Status.SeekedAt
+	}
+	job.transitions = append(record.Transitions, StateTransition{State: JobStateRunning, At: time.Now(), Reason: "resumed"})
+	return job
+}
@@ -0,0 +1,190 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testJobStore(t *testing.T, store JobStore) {
+	ctx := context.Background()
+	older := newJob("shovel-1", ShovelRequest{TargetTopic: "projects/test/topics/a"})
+	newer := newJob("shovel-2", ShovelRequest{TargetTopic: "projects/test/topics/b"})
+	newer.startedAt = older.startedAt.Add(time.Second)
+	newer.tryAccept(0)
+	newer.recordProcessed()
+
+	for _, job := range []*Job{newer, older} {
+		if err := store.Save(ctx, job.Record()); err != nil {
+			t.Fatalf("Failed to save record: %v", err)
+		}
+	}
+
+	record, err := store.Get(ctx, "shovel-2")
+	if err != nil {
+		t.Fatalf("Failed to get record: %v", err)
+	}
+	if record.Status.ProcessedCount != 1 || record.Request.TargetTopic != "projects/test/topics/b" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if len(record.Transitions) != 1 || record.Transitions[0].State != JobStateRunning {
+		t.Errorf("Expected initial running transition, got %+v", record.Transitions)
+	}
+
+	// Saving again replaces the record
+	newer.finish(nil)
+	if err := store.Save(ctx, newer.Record()); err != nil {
+		t.Fatalf("Failed to save record: %v", err)
+	}
+	record, _ = store.Get(ctx, "shovel-2")
+	if record.Status.State != JobStateCompleted || len(record.Transitions) != 2 {
+		t.Errorf("Expected completed record with 2 transitions, got %+v", record)
+	}
+
+	records, err := store.List(ctx, JobFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list records: %v", err)
+	}
+	if len(records) != 2 || records[0].ID != "shovel-1" {
+		t.Errorf("Expected 2 records ordered by start time, got %+v", records)
+	}
+
+	records, err = store.List(ctx, JobFilter{State: JobStateCompleted})
+	if err != nil {
+		t.Fatalf("Failed to list records: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != "shovel-2" {
+		t.Errorf("Expected only the completed record, got %+v", records)
+	}
+
+	if _, err := store.Get(ctx, "unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestMemoryJobStore(t *testing.T) {
+	testJobStore(t, NewMemoryJobStore())
+}
+
+func TestFileJobStore(t *testing.T) {
+	store, err := NewFileJobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	testJobStore(t, store)
+}
+
+func TestFileJobStore_RejectsPathTraversal(t *testing.T) {
+	store, err := NewFileJobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	for _, id := range []string{"../escape", "a/b", ".hidden", ""} {
+		if _, err := store.Get(context.Background(), id); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("Expected %q to be rejected, got %v", id, err)
+		}
+		if err := store.Save(context.Background(), JobRecord{ID: id}); err == nil {
+			t.Errorf("Expected saving %q to fail", id)
+		}
+	}
+}
+
+func TestNewJobStore(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: ""},
+		{spec: "memory"},
+		{spec: "file:" + t.TempDir()},
+		{spec: "file:", wantErr: true},
+		{spec: "firestore", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := NewJobStore(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJobRecord_Resumable(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    JobState
+		request  ShovelRequest
+		expected bool
+	}{
+		{name: "interrupted continuous", state: JobStateInterrupted, request: ShovelRequest{Mode: ModeContinuous}, expected: true},
+		{name: "crashed drain", state: JobStateRunning, request: ShovelRequest{AllMessages: true}, expected: true},
+		{name: "interrupted bounded", state: JobStateInterrupted, request: ShovelRequest{NumMessages: 10}},
+		{name: "cancelled continuous", state: JobStateCancelled, request: ShovelRequest{Mode: ModeContinuous}},
+		{name: "completed drain", state: JobStateCompleted, request: ShovelRequest{AllMessages: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := JobRecord{Request: tt.request, Status: JobStatus{State: tt.state}}
+			if record.resumable() != tt.expected {
+				t.Errorf("Expected resumable %v", tt.expected)
+			}
+		})
+	}
+}
+
+func TestResumeJobs(t *testing.T) {
+	srv := newShovelFixture(t, 2)
+	useTestServer(t, srv)
+
+	original := jobStore
+	store := NewMemoryJobStore()
+	SetJobStore(store)
+	defer SetJobStore(original)
+
+	ctx := context.Background()
+	interrupted := newJob("shovel-resume-test", ShovelRequest{
+		Mode:               ModeContinuous,
+		SourceSubscription: "projects/test/subscriptions/source-sub",
+		TargetTopic:        "projects/test/topics/target",
+	})
+	interrupted.tryAccept(0)
+	interrupted.recordProcessed()
+	interrupted.interrupt()
+	interrupted.finish(nil)
+	completed := newJob("shovel-resume-completed", ShovelRequest{AllMessages: true})
+	completed.finish(nil)
+	store.Save(ctx, interrupted.Record())
+	store.Save(ctx, completed.Record())
+
+	resumed, err := ResumeJobs(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resumed) != 1 || resumed[0] != "shovel-resume-test" {
+		t.Fatalf("Expected only the interrupted job to resume, got %v", resumed)
+	}
+
+	job := jobs.get("shovel-resume-test")
+	waitFor(t, "resumed job to forward messages", func() bool { return job.Status().ProcessedCount == 3 })
+	job.Cancel()
+	<-job.Done()
+
+	record, err := store.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Failed to get record: %v", err)
+	}
+	if record.Status.State != JobStateCancelled {
+		t.Errorf("Expected stored state %s, got %s", JobStateCancelled, record.Status.State)
+	}
+	var reasons []string
+	for _, tr := range record.Transitions {
+		reasons = append(reasons, string(tr.State)+":"+tr.Reason)
+	}
+	if len(record.Transitions) != 4 || record.Transitions[2].Reason != "resumed" {
+		t.Errorf("Expected created, interrupted, resumed and cancelled transitions, got %v", reasons)
+	}
+}
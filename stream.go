@@ -0,0 +1,174 @@
+package shovel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot interval limits of the event stream
+const (
+	defaultStreamInterval = time.Second
+	minStreamInterval     = 100 * time.Millisecond
+	maxStreamInterval     = time.Minute
+)
+
+// streamsClosed is closed when the server shuts down. Streams of continuous
+// jobs would otherwise keep the shutdown waiting until the drain timeout.
+var (
+	streamsClosed    = make(chan struct{})
+	closeStreamsOnce sync.Once
+)
+
+// CloseStreams ends all open progress streams. Register it with
+// http.Server.RegisterOnShutdown.
+func CloseStreams() {
+	closeStreamsOnce.Do(func() { close(streamsClosed) })
+}
+
+// ProgressSnapshot is one progress event of a job
+type ProgressSnapshot struct {
+	JobStatus
+	Rate       float64  `json:"rate"`                 // Processed messages per second since the previous snapshot
+	ETASeconds *float64 `json:"etaSeconds,omitempty"` // Remaining time for numMessages jobs, when a rate is known
+}
+
+// newProgressSnapshot derives rate and ETA from the change since prev, which
+// was taken elapsed ago. Without prev, the average rate since the start is used.
+func newProgressSnapshot(status JobStatus, req ShovelRequest, prev *JobStatus, elapsed time.Duration) ProgressSnapshot {
+	snapshot := ProgressSnapshot{JobStatus: status}
+	processed := status.ProcessedCount
+	if prev != nil {
+		processed -= prev.ProcessedCount
+	} else {
+		elapsed = time.Since(status.StartedAt)
+	}
+	if elapsed > 0 {
+		snapshot.Rate = float64(processed) / elapsed.Seconds()
+	}
+
+	if status.State == JobStateRunning && req.NumMessages > 0 && snapshot.Rate > 0 {
+		remaining := req.NumMessages - status.ProcessedCount - status.FailedCount
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta := float64(remaining) / snapshot.Rate
+		snapshot.ETASeconds = &eta
+	}
+	return snapshot
+}
+
+// StreamHandler streams progress snapshots of the job given by the {id} path
+// segment or the jobId query parameter until the job ends. Snapshots are sent
+// as Server-Sent Events, or as JSON lines when the client accepts
+// application/x-ndjson or passes format=ndjson. The interval query parameter
+// sets the time between snapshots (default 1s). Streams also end when the
+// server shuts down.
+func StreamHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if handleCORS(w, r, "GET") {
+		return
+	}
+	r, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	interval := defaultStreamInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minStreamInterval || d > maxStreamInterval {
+			respondWithError(w, fmt.Sprintf("interval must be a duration between %s and %s", minStreamInterval, maxStreamInterval), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	jobID := jobIDFromRequest(r)
+	job := jobs.get(jobID)
+	var final *JobStatus
+	if job == nil {
+		// Jobs of earlier instances are over, so their stream is a single snapshot
+		record, err := jobStore.Get(r.Context(), jobID)
+		if errors.Is(err, ErrJobNotFound) {
+			respondWithError(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Failed to load job: %v", err), http.StatusInternalServerError)
+			return
+		}
+		final = &record.Status
+	}
+
+	ndjson := r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(event string, snapshot ProgressSnapshot) error {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if ndjson {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		} else {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		}
+		if err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+
+	if final != nil {
+		if err := send("done", newProgressSnapshot(*final, ShovelRequest{}, nil, 0)); err != nil {
+			logger.Warn("Failed to stream progress", "jobId", jobID, "error", err)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var prev *JobStatus
+	last := time.Now()
+	for {
+		status := job.Status()
+		event := "progress"
+		if status.State != JobStateRunning {
+			event = "done"
+		}
+		now := time.Now()
+		if err := send(event, newProgressSnapshot(status, job.Request, prev, now.Sub(last))); err != nil {
+			logger.Warn("Failed to stream progress", "jobId", jobID, "error", err)
+			return
+		}
+		if event == "done" {
+			return
+		}
+		prev, last = &status, now
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-streamsClosed:
+			return
+		case <-job.Done():
+		case <-ticker.C:
+		}
+	}
+}
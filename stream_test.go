@@ -0,0 +1,177 @@
+package shovel
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewProgressSnapshot(t *testing.T) {
+	prev := JobStatus{State: JobStateRunning, ProcessedCount: 100}
+	status := JobStatus{State: JobStateRunning, ProcessedCount: 300, FailedCount: 100}
+
+	snapshot := newProgressSnapshot(status, ShovelRequest{NumMessages: 1000}, &prev, 2*time.Second)
+	if snapshot.Rate != 100 {
+		t.Errorf("Expected rate 100, got %v", snapshot.Rate)
+	}
+	if snapshot.ETASeconds == nil || *snapshot.ETASeconds != 6 {
+		t.Errorf("Expected ETA of 6s for 600 remaining messages, got %v", snapshot.ETASeconds)
+	}
+
+	snapshot = newProgressSnapshot(status, ShovelRequest{AllMessages: true}, &prev, 2*time.Second)
+	if snapshot.ETASeconds != nil {
+		t.Errorf("Expected no ETA without numMessages, got %v", *snapshot.ETASeconds)
+	}
+
+	snapshot = newProgressSnapshot(status, ShovelRequest{NumMessages: 1000}, &status, 2*time.Second)
+	if snapshot.Rate != 0 || snapshot.ETASeconds != nil {
+		t.Errorf("Expected no rate or ETA without progress, got %v and %v", snapshot.Rate, snapshot.ETASeconds)
+	}
+}
+
+func TestStreamHandler_NDJSON(t *testing.T) {
+	job := newJob("shovel-stream-test", ShovelRequest{NumMessages: 10})
+	jobs.add(job)
+	srv := httptest.NewServer(NewServeMux())
+	defer srv.Close()
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			job.tryAccept(0)
+			job.recordProcessed()
+			time.Sleep(50 * time.Millisecond)
+		}
+		job.finish(nil)
+	}()
+
+	resp, err := http.Get(srv.URL + "/jobs/" + job.ID + "/events?format=ndjson&interval=100ms")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON content type, got %s", ct)
+	}
+
+	var snapshots []ProgressSnapshot
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var snapshot ProgressSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			t.Fatalf("Failed to decode snapshot %q: %v", scanner.Text(), err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if len(snapshots) < 2 {
+		t.Fatalf("Expected several snapshots, got %d", len(snapshots))
+	}
+	last := snapshots[len(snapshots)-1]
+	if last.State != JobStateCompleted || last.ProcessedCount != 5 {
+		t.Errorf("Expected stream to end with the final state, got %+v", last.JobStatus)
+	}
+}
+
+func TestStreamHandler_ServerSentEvents(t *testing.T) {
+	job := newJob("shovel-stream-sse-test", ShovelRequest{Mode: ModeContinuous})
+	jobs.add(job)
+	time.AfterFunc(150*time.Millisecond, func() {
+		job.Cancel()
+		job.finish(nil)
+	})
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/Events?jobId="+job.ID+"&interval=100ms", nil)
+	StreamHandler(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected event stream content type, got %s", ct)
+	}
+	body := rr.Body.String()
+	if !strings.HasPrefix(body, "event: progress\ndata: {") {
+		t.Errorf("Expected progress events, got %q", body)
+	}
+	if !strings.Contains(body, "event: done\ndata: {") || !strings.Contains(body, `"state":"cancelled"`) {
+		t.Errorf("Expected a final done event, got %q", body)
+	}
+}
+
+func TestStreamHandler_EndsOnShutdown(t *testing.T) {
+	t.Cleanup(func() {
+		streamsClosed = make(chan struct{})
+		closeStreamsOnce = sync.Once{}
+	})
+	job := newJob("shovel-stream-shutdown-test", ShovelRequest{Mode: ModeContinuous})
+	jobs.add(job)
+	defer job.finish(nil)
+
+	// Shutdown runs its hooks in the background, wait for them before the
+	// cleanup resets the streams
+	hooked := make(chan struct{})
+	srv := httptest.NewUnstartedServer(NewServeMux())
+	srv.Config.RegisterOnShutdown(func() {
+		defer close(hooked)
+		CloseStreams()
+	})
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/jobs/" + job.ID + "/events?format=ndjson&interval=100ms")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The stream of the still running job must not hold up the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the shutdown to end the stream, got %v", err)
+	}
+	<-hooked
+	if job.Status().State != JobStateRunning {
+		t.Errorf("Expected the job to keep running, got %s", job.Status().State)
+	}
+}
+
+func TestStreamHandler_StoredAndUnknownJobs(t *testing.T) {
+	original := jobStore
+	SetJobStore(NewMemoryJobStore())
+	defer SetJobStore(original)
+
+	stored := newJob("shovel-stream-stored-test", ShovelRequest{})
+	stored.finish(nil)
+
+	tests := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "stored job", query: "jobId=" + stored.ID, expectedCode: http.StatusOK, expectedBody: "event: done\n"},
+		{name: "unknown job", query: "jobId=unknown", expectedCode: http.StatusNotFound},
+		{name: "invalid interval", query: "jobId=" + stored.ID + "&interval=1ms", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			rr := httptest.NewRecorder()
+			StreamHandler(rr, httptest.NewRequest("GET", "/Events?"+tt.query, nil).WithContext(ctx))
+			if rr.Code != tt.expectedCode {
+				t.Errorf("Expected status code %d, got %d", tt.expectedCode, rr.Code)
+			}
+			body, _ := io.ReadAll(rr.Body)
+			if !strings.HasPrefix(string(body), tt.expectedBody) {
+				t.Errorf("Expected body to start with %q, got %q", tt.expectedBody, body)
+			}
+		})
+	}
+}
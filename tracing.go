@@ -0,0 +1,125 @@
+package shovel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the shovel pipeline. It uses the global tracer
+// provider, which does nothing until a program installs one (see cmd/server).
+var tracer = otel.Tracer("github.com/torbendury/pubsub-shovel")
+
+// traceContext propagates W3C trace context through HTTP headers and message attributes
+var traceContext = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// attributeCarrier adapts message attributes to a propagation.TextMapCarrier
+type attributeCarrier map[string]string
+
+func (c attributeCarrier) Get(key string) string { return c[key] }
+
+func (c attributeCarrier) Set(key, value string) { c[key] = value }
+
+func (c attributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// requestTraceContext returns a context carrying the trace of an incoming HTTP
+// request that is not cancelled when the request ends
+func requestTraceContext(ctx context.Context, header propagation.HeaderCarrier) context.Context {
+	return context.WithoutCancel(traceContext.Extract(ctx, header))
+}
+
+// startJobSpan starts the span covering a whole job
+func (j *Job) startJobSpan(ctx context.Context) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "shovel.job", trace.WithAttributes(
+		attribute.String("shovel.job.id", j.ID),
+		attribute.String("shovel.job.mode", j.Status().Mode),
+		attribute.String("shovel.source", metricsSource(j.Request)),
+		attribute.String("shovel.target", j.Request.TargetTopic),
+	))
+}
+
+// endJobSpan records the final job counters on span and ends it
+func (j *Job) endJobSpan(span trace.Span) {
+	status := j.Status()
+	span.SetAttributes(
+		attribute.String("shovel.job.state", string(status.State)),
+		attribute.String("shovel.job.stop_reason", status.StopReason),
+		attribute.Int("shovel.messages.processed", status.ProcessedCount),
+		attribute.Int("shovel.messages.failed", status.FailedCount),
+		attribute.Int("shovel.messages.nacked", status.NackedCount),
+	)
+	if status.Error != "" {
+		span.SetStatus(codes.Error, status.Error)
+	}
+	span.End()
+}
+
+// startMessageSpan starts the span of one message as a child of the job span
+// in ctx. With propagateTrace, a trace context found in the message
+// attributes is linked so that replayed messages lead back to their origin.
+func (j *Job) startMessageSpan(ctx context.Context, id string, size int, attributes map[string]string) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.message.id", id),
+			attribute.Int("messaging.message.body.size", size),
+		),
+	}
+	if j.Request.PropagateTrace {
+		origin := trace.SpanContextFromContext(traceContext.Extract(context.Background(), attributeCarrier(attributes)))
+		if origin.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: origin}))
+		}
+	}
+	return tracer.Start(ctx, "shovel.message", opts...)
+}
+
+// outgoingAttributes returns the attributes to publish for a message. With
+// propagateTrace, the trace context of ctx replaces any inherited one.
+func (j *Job) outgoingAttributes(ctx context.Context, attributes map[string]string) map[string]string {
+	_, span := tracer.Start(ctx, "shovel.transform")
+	defer span.End()
+	if !j.Request.PropagateTrace {
+		return attributes
+	}
+
+	out := make(map[string]string, len(attributes)+2)
+	for k, v := range attributes {
+		out[k] = v
+	}
+	traceContext.Inject(ctx, attributeCarrier(out))
+	return out
+}
+
+// startPublishSpan starts the span that lasts until the publish of a message completed
+func startPublishSpan(ctx context.Context) trace.Span {
+	_, span := tracer.Start(ctx, "shovel.publish", trace.WithSpanKind(trace.SpanKindProducer))
+	return span
+}
+
+// traceStage runs fn inside a span named name
+func traceStage(ctx context.Context, name string, fn func() error) error {
+	_, span := tracer.Start(ctx, name)
+	err := fn()
+	endSpan(span, err)
+	return err
+}
+
+// endSpan ends span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,97 @@
+package shovel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans routes the shovel spans into a recorder for the rest of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	original := tracer
+	tracer = provider.Tracer("test")
+	t.Cleanup(func() { tracer = original })
+	return recorder
+}
+
+func TestTracing_PropagatesTraceContext(t *testing.T) {
+	recorder := recordSpans(t)
+	srv := newShovelFixture(t, 0)
+	useTestServer(t, srv)
+
+	const requestTrace = "0af7651916cd43dd8448eb211c80319c"
+	const originTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	srv.Publish("projects/test/topics/source", []byte("replayed"), map[string]string{
+		"traceparent": "00-" + originTrace + "-00f067aa0ba902b7-01",
+		"type":        "order",
+	})
+
+	body := `{"mode": "continuous", "propagateTrace": true, "sourceSubscription": "projects/test/subscriptions/source-sub", "targetTopic": "projects/test/topics/target"}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("traceparent", "00-"+requestTrace+"-b7ad6b7169203331-01")
+	rr := httptest.NewRecorder()
+	Handler(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	var resp ShovelResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	job := jobs.get(resp.RequestID)
+	waitFor(t, "the message to be processed", func() bool { return job.Status().ProcessedCount == 1 })
+	job.Cancel()
+	<-job.Done()
+
+	var published string
+	for _, m := range srv.Messages() {
+		if string(m.Data) == "replayed" && m.Attributes["traceparent"] != "" && !strings.Contains(m.Attributes["traceparent"], originTrace) {
+			published = m.Attributes["traceparent"]
+			if m.Attributes["type"] != "order" {
+				t.Errorf("Expected attributes to be kept, got %v", m.Attributes)
+			}
+		}
+	}
+	if !strings.Contains(published, requestTrace) {
+		t.Errorf("Expected published message to carry the request trace, got %q", published)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		if span.SpanContext().TraceID().String() != requestTrace {
+			t.Errorf("Expected span %s to belong to the request trace, got %s", span.Name(), span.SpanContext().TraceID())
+		}
+	}
+	for _, name := range []string{"shovel.job", "shovel.message", "shovel.transform", "shovel.publish", "shovel.ack"} {
+		if spans[name] == nil {
+			t.Errorf("Expected a %s span", name)
+		}
+	}
+	if msg := spans["shovel.message"]; msg != nil {
+		links := msg.Links()
+		if len(links) != 1 || links[0].SpanContext.TraceID().String() != originTrace {
+			t.Errorf("Expected message span to link to the original trace, got %v", links)
+		}
+	}
+}
+
+func TestTracing_DisabledPropagationKeepsAttributes(t *testing.T) {
+	job := newJob("test", ShovelRequest{})
+	attributes := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+
+	ctx, span := job.startMessageSpan(t.Context(), "1", 0, attributes)
+	defer span.End()
+	out := job.outgoingAttributes(ctx, attributes)
+	if out["traceparent"] != attributes["traceparent"] {
+		t.Errorf("Expected attributes to pass through unchanged, got %v", out)
+	}
+}